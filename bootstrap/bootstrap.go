@@ -1,19 +1,36 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
 	"github.com/eatmoreapple/openwechat"
 	"github.com/qingconglaixueit/wechatbot/handlers"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/service"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func Run() {
 	//bot := openwechat.DefaultBot()
 	bot := openwechat.DefaultBot(openwechat.Desktop) // 桌面模式，上面登录不上的可以尝试切换这种模式
 
+	// 收到退出信号或者退出登录时取消所有进行中的请求
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	// 退出时关闭会话存储，bolt写完数据并释放文件锁
+	defer service.CloseSessionStore()
+	bot.LogoutCallBack = func(bot *openwechat.Bot) {
+		cancel()
+	}
+	go func() {
+		<-ctx.Done()
+		bot.Exit()
+	}()
+
 	// 注册消息处理函数
-	handler, err := handlers.NewHandler()
+	handler, err := handlers.NewHandler(ctx)
 	if err != nil {
 		logger.Danger(fmt.Sprintf("handlers.NewHandler error: %v", err))
 		return
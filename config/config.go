@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,10 +16,46 @@ import (
 type Configuration struct {
 	// gpt apikey
 	ApiKey string `json:"api_key"`
+	// api key池，配置后替代api_key，多个key按策略轮流使用
+	ApiKeys []ApiKeyConfiguration `json:"api_keys"`
+	// key选择策略：round_robin 加权轮询，least_used 最少使用
+	KeyStrategy string `json:"key_strategy"`
+	// 无效或额度用完被隔离的key重新探测间隔，单位秒，0表示不探测
+	KeyProbeInterval time.Duration `json:"key_probe_interval"`
 	// 自动通过好友
 	AutoPass bool `json:"auto_pass"`
 	// 会话超时时间
 	SessionTimeout time.Duration `json:"session_timeout"`
+	// 会话存储：memory 内存，bolt 本地文件，redis
+	SessionStore string `json:"session_store"`
+	// bolt存储的文件
+	SessionFile string `json:"session_file"`
+	// redis存储的地址、密码和库
+	RedisAddr     string `json:"redis_addr"`
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	// 使用共享上下文的群昵称，群里所有人共用一份上下文并记录发言人，"*"表示所有群
+	SharedContextGroups []string `json:"shared_context_groups"`
+	// 切换群共享上下文指令
+	SharedContextCommand string `json:"shared_context_command"`
+	// 角色名 -> system prompt
+	Roles map[string]string `json:"roles"`
+	// 默认角色，为空时不使用system prompt
+	DefaultRole string `json:"default_role"`
+	// 角色指令
+	RoleCommand string `json:"role_command"`
+	// 会话历史最多保留的token数，为0时按模型上下文窗口
+	HistoryMaxTokens int `json:"history_max_tokens"`
+	// 会话历史超出上限时把较早的对话压缩成摘要，关闭时直接丢弃
+	SummarizeHistory bool `json:"summarize_history"`
+	// 压缩摘要使用的模型，为空时使用默认模型
+	SummaryModel string `json:"summary_model"`
+	// 重新回答上一个问题指令
+	RetryCommand string `json:"retry_command"`
+	// 撤销上一轮问答指令
+	UndoCommand string `json:"undo_command"`
+	// 多会话指令
+	SessionCommands SessionCommandConfiguration `json:"session_commands"`
 	// GPT请求最大字符数
 	MaxTokens uint `json:"max_tokens"`
 	// GPT模型
@@ -29,6 +66,157 @@ type Configuration struct {
 	ReplyPrefix string `json:"reply_prefix"`
 	// 清空会话口令
 	SessionClearToken string `json:"session_clear_token"`
+	// 取消回复口令，取消进行中的GPT请求
+	CancelToken string `json:"cancel_token"`
+	// 单次请求超时时间（包含重试），单位秒，流式回复整体不受限制，两次收到数据的间隔不超过这个时间
+	RequestTimeout time.Duration `json:"request_timeout"`
+	// 大模型服务提供方：openai、azure、local(兼容OpenAI接口的本地服务)
+	Provider string `json:"provider"`
+	// 接口地址，openai可指向内部网关，local必填，如 http://127.0.0.1:11434/v1
+	BaseURL string `json:"base_url"`
+	// Azure OpenAI 配置
+	Azure AzureConfiguration `json:"azure"`
+	// 是否流式回复，按段落分批发送
+	Stream bool `json:"stream"`
+	// 流式回复每段最少字符数
+	StreamChunkSize int `json:"stream_chunk_size"`
+	// 模型上下文窗口（token数），未配置的模型使用内置值
+	ContextWindows map[string]int `json:"context_windows"`
+	// 启用的内置工具：calculator、datetime、notes，为空时不使用工具
+	Tools []string `json:"tools"`
+	// 每次回答最多几轮工具调用
+	MaxToolRounds int `json:"max_tool_rounds"`
+	// notes工具查询的笔记文件
+	NotesFile string `json:"notes_file"`
+	// 画图指令前缀
+	ImageCommand string `json:"image_command"`
+	// 画图模型，为空时使用接口默认模型，Azure需要配置为部署对应的模型
+	ImageModel string `json:"image_model"`
+	// 默认图片尺寸
+	ImageSize string `json:"image_size"`
+	// 是否识别私聊语音消息
+	Voice bool `json:"voice"`
+	// 是否识别群聊语音消息，群里的语音要先转写才知道是不是叫机器人，每条都会上传转写，默认关闭
+	GroupVoice bool `json:"group_voice"`
+	// 语音转写模型
+	TranscriptionModel string `json:"transcription_model"`
+	// 语音语言，如zh，为空时自动识别
+	TranscriptionLanguage string `json:"transcription_language"`
+	// 群聊语音没法@，转写内容包含机器人昵称或唤醒词才回复
+	VoiceWakeWords []string `json:"voice_wake_words"`
+	// 是否默认用语音回复，每个私聊、群可以用指令单独开关
+	SpeechReply bool `json:"speech_reply"`
+	// 语音回复开关指令
+	SpeechCommand string `json:"speech_command"`
+	// 语音合成模型
+	SpeechModel string `json:"speech_model"`
+	// 语音合成音色
+	SpeechVoice string `json:"speech_voice"`
+	// 语速，0.25-4，为0时使用接口默认值
+	SpeechSpeed float64 `json:"speech_speed"`
+	// 超过该字数的回复不合成语音，直接发文字
+	SpeechMaxLength int `json:"speech_max_length"`
+	// 是否识别私聊收到的图片
+	Vision bool `json:"vision"`
+	// 是否识别群里的图片，群里的图片不一定是给机器人的，默认关闭，开启后只放在内存中，发图的人@机器人提问时带上一次
+	GroupVision bool `json:"group_vision"`
+	// 识图模型，提问带图片时使用
+	VisionModel string `json:"vision_model"`
+	// 图片识别精度：low、high、auto
+	VisionDetail string `json:"vision_detail"`
+	// 收到的图片在会话中保留多久，单位秒
+	VisionImageTimeout time.Duration `json:"vision_image_timeout"`
+	// 用量记录文件
+	UsageFile string `json:"usage_file"`
+	// 查询用量指令
+	UsageCommand string `json:"usage_command"`
+	// 管理员的用户ID或昵称，可以查看所有人的用量
+	Admins []string `json:"admins"`
+	// 模型价格，未配置的模型使用内置价格
+	ModelPrices map[string]ModelPrice `json:"model_prices"`
+	// 可以切换的模型，为空时只能使用Model
+	Models map[string]ModelConfiguration `json:"models"`
+	// 切换模型指令
+	ModelCommand string `json:"model_command"`
+	// 模型超时、服务端错误或过载时依次尝试的备用模型
+	Fallbacks []FallbackConfiguration `json:"fallbacks"`
+	// 配置了备用模型时，每个模型最多等待多久再换下一个，单位秒
+	FallbackTimeout time.Duration `json:"fallback_timeout"`
+	// 回复因为max_tokens被截断时最多自动接着写几次，为0时不接着写
+	MaxContinuations int `json:"max_continuations"`
+	// 单条回复最多多少个字符，超过时拆成多条发送，为0时不拆分
+	ReplyMaxLength int `json:"reply_max_length"`
+	// 拆分发送时每条之间的间隔，单位秒，实际间隔在1到2倍之间随机
+	ReplyInterval time.Duration `json:"reply_interval"`
+	// 回复中有代码块、表格或公式时渲染成图片发送，同时发送去掉markdown标记的文字
+	RenderMarkdown bool `json:"render_markdown"`
+	// 渲染图片使用的字体文件，为空时查找系统中文字体
+	RenderFont string `json:"render_font"`
+	// 渲染图片的宽度，像素
+	RenderWidth int `json:"render_width"`
+}
+
+// FallbackConfiguration 备用模型
+type FallbackConfiguration struct {
+	Model string `json:"model"`
+	// 提供方，为空时使用主配置的提供方和key池
+	Provider string `json:"provider"`
+	// 接口地址，openai、local使用
+	BaseURL string `json:"base_url"`
+	// 提供方不为空时使用的api key
+	ApiKey string `json:"api_key"`
+}
+
+// ModelConfiguration 可切换模型的参数
+type ModelConfiguration struct {
+	// 单次提问指令，如gpt4时发送 /gpt4 问题，为空时使用 /模型名
+	Command string `json:"command"`
+	// 为0时使用全局的max_tokens
+	MaxTokens uint `json:"max_tokens"`
+	// 为空时使用全局的temperature
+	Temperature *float64 `json:"temperature"`
+}
+
+// ModelPrice 模型每1K token的美元价格
+type ModelPrice struct {
+	// 提问
+	Prompt float64 `json:"prompt"`
+	// 回复
+	Completion float64 `json:"completion"`
+}
+
+// ApiKeyConfiguration key池中的key
+type ApiKeyConfiguration struct {
+	// api key
+	Key string `json:"key"`
+	// 权重，默认1
+	Weight int `json:"weight"`
+	// OpenAI组织ID，可选
+	Organization string `json:"organization"`
+}
+
+// SessionCommandConfiguration 多会话指令，为空时不启用该指令
+type SessionCommandConfiguration struct {
+	// 创建并切换到新会话
+	New string `json:"new"`
+	// 切换会话
+	Switch string `json:"switch"`
+	// 列出会话
+	List string `json:"list"`
+	// 重命名会话
+	Rename string `json:"rename"`
+	// 删除会话
+	Delete string `json:"delete"`
+}
+
+// AzureConfiguration Azure OpenAI 配置
+type AzureConfiguration struct {
+	// 资源地址，如 https://xxx.openai.azure.com
+	Endpoint string `json:"endpoint"`
+	// 接口版本
+	APIVersion string `json:"api_version"`
+	// 模型与部署名称的映射
+	Deployments map[string]string `json:"deployments"`
 }
 
 var config *Configuration
@@ -39,12 +227,56 @@ func LoadConfig() *Configuration {
 	once.Do(func() {
 		// 给配置赋默认值
 		config = &Configuration{
-			AutoPass:          false,
-			SessionTimeout:    60,
-			MaxTokens:         512,
-			Model:             "text-davinci-003",
-			Temperature:       0.9,
-			SessionClearToken: "下个问题",
+			AutoPass:             false,
+			SessionTimeout:       60,
+			SessionStore:         "memory",
+			SessionFile:          "sessions.db",
+			RedisAddr:            "127.0.0.1:6379",
+			SharedContextCommand: "/shared",
+			RoleCommand:          "/role",
+			RetryCommand:         "/retry",
+			UndoCommand:          "/undo",
+			HistoryMaxTokens:     4000,
+			SummarizeHistory:     true,
+			MaxTokens:            512,
+			Model:                "gpt-3.5-turbo",
+			Temperature:          0.9,
+			SessionClearToken:    "下个问题",
+			CancelToken:          "取消",
+			RequestTimeout:       60,
+			Provider:             "openai",
+			KeyStrategy:          "round_robin",
+			KeyProbeInterval:     600,
+			StreamChunkSize:      200,
+			MaxToolRounds:        5,
+			NotesFile:            "notes.json",
+			ImageCommand:         "/img",
+			ImageSize:            "1024x1024",
+			Voice:                true,
+			TranscriptionModel:   "whisper-1",
+			SpeechCommand:        "/voice",
+			SpeechModel:          "tts-1",
+			SpeechVoice:          "alloy",
+			SpeechMaxLength:      500,
+			Vision:               true,
+			VisionModel:          "gpt-4o",
+			VisionDetail:         "auto",
+			VisionImageTimeout:   300,
+			UsageFile:            "usage.json",
+			UsageCommand:         "/usage",
+			ModelCommand:         "/model",
+			FallbackTimeout:      30,
+			MaxContinuations:     2,
+			ReplyMaxLength:       1000,
+			ReplyInterval:        1,
+			RenderWidth:          900,
+			SessionCommands: SessionCommandConfiguration{
+				New:    "/new",
+				Switch: "/switch",
+				List:   "/sessions",
+				Rename: "/rename",
+				Delete: "/delete",
+			},
 		}
 
 		// 判断配置文件是否存在，存在直接JSON读取
@@ -72,8 +304,27 @@ func LoadConfig() *Configuration {
 		Temperature := os.Getenv("TEMPREATURE")
 		ReplyPrefix := os.Getenv("REPLY_PREFIX")
 		SessionClearToken := os.Getenv("SESSION_CLEAR_TOKEN")
+		CancelToken := os.Getenv("CANCEL_TOKEN")
+		RequestTimeout := os.Getenv("REQUEST_TIMEOUT")
+		Provider := os.Getenv("PROVIDER")
+		BaseURL := os.Getenv("BASE_URL")
+		AzureEndpoint := os.Getenv("AZURE_ENDPOINT")
+		AzureAPIVersion := os.Getenv("AZURE_API_VERSION")
+		Stream := os.Getenv("STREAM")
+		Voice := os.Getenv("VOICE")
+		SessionStore := os.Getenv("SESSION_STORE")
+		RedisAddr := os.Getenv("REDIS_ADDR")
+		RedisPassword := os.Getenv("REDIS_PASSWORD")
 		if ApiKey != "" {
-			config.ApiKey = ApiKey
+			// 多个key用英文逗号分隔，作为key池使用
+			keys := strings.Split(ApiKey, ",")
+			config.ApiKey = strings.TrimSpace(keys[0])
+			if len(keys) > 1 {
+				config.ApiKeys = nil
+				for _, key := range keys {
+					config.ApiKeys = append(config.ApiKeys, ApiKeyConfiguration{Key: strings.TrimSpace(key), Weight: 1})
+				}
+			}
 		}
 		if AutoPass == "true" {
 			config.AutoPass = true
@@ -111,11 +362,62 @@ func LoadConfig() *Configuration {
 		if SessionClearToken != "" {
 			config.SessionClearToken = SessionClearToken
 		}
+		if CancelToken != "" {
+			config.CancelToken = CancelToken
+		}
+		if RequestTimeout != "" {
+			timeout, err := parseRequestTimeout(RequestTimeout)
+			if err != nil {
+				logger.Danger(fmt.Sprintf("config request timeout error: %v, get is %v", err, RequestTimeout))
+				return
+			}
+			config.RequestTimeout = timeout
+		}
+		if Provider != "" {
+			config.Provider = Provider
+		}
+		if BaseURL != "" {
+			config.BaseURL = BaseURL
+		}
+		if AzureEndpoint != "" {
+			config.Azure.Endpoint = AzureEndpoint
+		}
+		if AzureAPIVersion != "" {
+			config.Azure.APIVersion = AzureAPIVersion
+		}
+		if Stream == "true" {
+			config.Stream = true
+		}
+		if Voice == "false" {
+			config.Voice = false
+		}
+		if SessionStore != "" {
+			config.SessionStore = SessionStore
+		}
+		if RedisAddr != "" {
+			config.RedisAddr = RedisAddr
+		}
+		if RedisPassword != "" {
+			config.RedisPassword = RedisPassword
+		}
 
 	})
-	if config.ApiKey == "" {
+	if config.ApiKey == "" && len(config.ApiKeys) == 0 && config.Provider != "local" {
 		logger.Danger("config error: api key required")
 	}
 
 	return config
 }
+
+// parseRequestTimeout 解析环境变量中的请求超时时间，如 90s、2m，转成和配置文件一致的秒数，
+// 不足1秒的会变成0（不限制），直接报错
+func parseRequestTimeout(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < time.Second {
+		return 0, fmt.Errorf("request timeout must be at least 1s")
+	}
+	return duration / time.Second, nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"90s", 90, false},
+		{"2m", 120, false},
+		{"1s", 1, false},
+		{"500ms", 0, true},
+		{"0s", 0, true},
+		{"-5s", 0, true},
+		{"60", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRequestTimeout(tt.value)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// TranscriptionResponseBody 语音转写响应体
+type TranscriptionResponseBody struct {
+	Text string `json:"text"`
+}
+
+// Transcribe 语音转文字，filename的后缀决定音频格式
+// curl https://api.openai.com/v1/audio/transcriptions
+// -H "Authorization: Bearer your chatGPT key"
+// -F file="@voice.mp3"
+// -F model="whisper-1"
+func Transcribe(ctx context.Context, filename string, audio []byte) (string, error) {
+	cfg := config.LoadConfig()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return "", fmt.Errorf("multipart create file error: %v", err)
+	}
+	if _, err = part.Write(audio); err != nil {
+		return "", fmt.Errorf("multipart write file error: %v", err)
+	}
+	fields := map[string]string{
+		"model":           cfg.TranscriptionModel,
+		"response_format": "json",
+		"language":        cfg.TranscriptionLanguage,
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err = writer.WriteField(name, value); err != nil {
+			return "", fmt.Errorf("multipart write field error: %v", err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("multipart close error: %v", err)
+	}
+	log.Printf("gpt transcription request file: %s, size: %d\n", filename, len(audio))
+
+	header := http.Header{}
+	header.Set("Content-Type", writer.FormDataContentType())
+	request := apiRequest{
+		method: http.MethodPost,
+		path:   "/audio/transcriptions",
+		model:  cfg.TranscriptionModel,
+		header: header,
+		body:   buf.Bytes(),
+	}
+
+	var text string
+	err = withRetry(ctx, "gpt transcription", func(attempt int) error {
+		response, err := doRequest(ctx, http.DefaultClient, request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("ioutil.ReadAll error: %w", err)
+		}
+		log.Printf("gpt transcription response(%d) json: %s\n", attempt, string(body))
+		transcriptionResponseBody := &TranscriptionResponseBody{}
+		if err := json.Unmarshal(body, transcriptionResponseBody); err != nil {
+			return fmt.Errorf("json.Unmarshal responseBody error: %v", err)
+		}
+		text = strings.TrimSpace(transcriptionResponseBody.Text)
+		return nil
+	})
+	return text, err
+}
@@ -0,0 +1,74 @@
+package gpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// apiRequest 接口请求参数
+type apiRequest struct {
+	method string
+	// 相对OpenAI /v1 的路径，如 /chat/completions
+	path string
+	// 模型，Azure按模型选择部署
+	model  string
+	header http.Header
+	body   []byte
+	// 备用模型的服务，为空时使用配置的提供方和key池
+	endpoint *endpoint
+}
+
+// doRequest 发送接口请求，从key池选择key，key无效或额度用完时隔离该key并立即换下一个key重试
+// 返回200的响应，由调用方读取并关闭响应体，其他状态码解析为APIError
+func doRequest(ctx context.Context, client *http.Client, request apiRequest) (*http.Response, error) {
+	if request.endpoint != nil {
+		return send(ctx, client, request.endpoint.provider, request.endpoint.credential, request)
+	}
+	provider, err := NewProvider(config.LoadConfig())
+	if err != nil {
+		return nil, err
+	}
+	pool := defaultKeyPool()
+	for {
+		key, err := pool.Acquire()
+		if err != nil {
+			return nil, err
+		}
+		response, err := send(ctx, client, provider, key.Credential, request)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && pool.Report(key, apiErr) {
+			continue
+		}
+		return response, err
+	}
+}
+
+// send 使用指定凭证发送一次请求，非200的响应解析为APIError
+func send(ctx context.Context, client *http.Client, provider Provider, credential Credential, request apiRequest) (*http.Response, error) {
+	req, err := provider.NewRequest(ctx, credential, request.method, request.path, request.model, bytes.NewReader(request.body))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest error: %v", err)
+	}
+	for name, values := range request.header {
+		req.Header[name] = values
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do error: %w", err)
+	}
+	if response.StatusCode == http.StatusOK {
+		return response, nil
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	log.Printf("gpt %s response error: %s\n", request.path, string(body))
+	return nil, newAPIError(response, body)
+}
@@ -0,0 +1,58 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+const (
+	// FinishReasonLength 回复达到max_tokens被截断
+	FinishReasonLength = "length"
+	// continuePrompt 回复被截断时请求模型接着写的提示
+	continuePrompt = "你的回答被截断了，请从中断的地方直接接着写，不要重复已经写过的内容，也不要加任何说明"
+)
+
+// shouldContinue 回复被截断并且还没达到配置的接着写次数时继续请求
+func shouldContinue(finishReason string, round int) bool {
+	return finishReason == FinishReasonLength && round < config.LoadConfig().MaxContinuations
+}
+
+// continueMessages 把已经收到的回复作为assistant消息，再请求模型接着写
+func continueMessages(messages []Message, reply string) []Message {
+	continued := make([]Message, 0, len(messages)+2)
+	continued = append(continued, messages...)
+	return append(continued,
+		Message{Role: RoleAssistant, Content: reply},
+		Message{Role: RoleUser, Content: continuePrompt},
+	)
+}
+
+// continueCompletions 回复因为max_tokens被截断时请求模型接着写，拼接各段回复，接着写失败时返回已有的回复，
+// requestBody为实际回答第一段的请求，备用模型回答的接着用备用模型，不回到超时或者过载的模型
+func continueCompletions(ctx context.Context, requestBody ChatCompletionRequestBody, reply, finishReason string, usage *Usage) string {
+	messages := requestBody.Messages
+	for round := 0; shouldContinue(finishReason, round); round++ {
+		log.Printf("gpt reply truncated, continue(%d)\n", round+1)
+		body := requestBody
+		body.Tools = nil
+		body.Messages = continueMessages(messages, reply)
+		gptResponseBody, err := chatCompletions(ctx, &body)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("gpt continue error: %v", err))
+			break
+		}
+		usage.Add(responseUsage(body, gptResponseBody))
+		// 接着写又换了备用模型时，之后的几轮也用它
+		requestBody.Model, requestBody.endpoint = body.Model, body.endpoint
+		if len(gptResponseBody.Choices) == 0 {
+			break
+		}
+		reply += gptResponseBody.Choices[0].Message.Content
+		finishReason = gptResponseBody.Choices[0].FinishReason
+	}
+	return reply
+}
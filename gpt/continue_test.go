@@ -0,0 +1,84 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// useFallbackServer 主模型返回500，备用模型第一次回复被截断，接着写时回复完整，响应不带模型，返回每次请求的模型
+func useFallbackServer(t *testing.T) func() []string {
+	var lock sync.Mutex
+	var models []string
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body ChatCompletionRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		lock.Lock()
+		models = append(models, body.Model)
+		lock.Unlock()
+		if body.Model == testModel {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusInternalServerError)
+			return
+		}
+		content, finishReason := "part1", FinishReasonLength
+		if len(body.Messages) > 1 {
+			content, finishReason = "part2", "stop"
+		}
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q},\"finish_reason\":%q}]}\n\n", content, finishReason)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q},"finish_reason":%q}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, content, finishReason)
+	})
+	cfg.Fallbacks = []config.FallbackConfiguration{{Model: "gpt-4"}}
+	cfg.MaxContinuations = 1
+	return func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return models
+	}
+}
+
+func TestContinueOnFallbackModel(t *testing.T) {
+	messages := []Message{{Role: RoleUser, Content: "hi"}}
+	tests := []struct {
+		name string
+		call func() (string, Usage, error)
+	}{
+		{"ChatCompletions", func() (string, Usage, error) {
+			return ChatCompletions(context.Background(), NewModelSettings(testModel), messages)
+		}},
+		{"ChatCompletionsStream", func() (string, Usage, error) {
+			return ChatCompletionsStream(context.Background(), NewModelSettings(testModel), messages, func(string) error { return nil })
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := useFallbackServer(t)
+			reply, usage, err := tt.call()
+			if err != nil || reply != "part1part2" {
+				t.Fatalf("reply = %q, %v, want part1part2", reply, err)
+			}
+			if usage.Model != "gpt-4" {
+				t.Errorf("usage model = %q, want gpt-4", usage.Model)
+			}
+			// 主模型重试之后换备用模型，接着写不再回到主模型
+			models := requests()
+			if last := models[len(models)-1]; last != "gpt-4" || models[len(models)-2] != "gpt-4" {
+				t.Errorf("requested models = %v, want the continuation on gpt-4", models)
+			}
+			for _, model := range models[:len(models)-2] {
+				if model != testModel {
+					t.Errorf("requested models = %v, want %s before the fallback", models, testModel)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind 接口错误类型
+type ErrorKind int
+
+const (
+	// ErrorUnknown 未归类的错误
+	ErrorUnknown ErrorKind = iota
+	// ErrorRateLimit 请求频率超限，稍后可重试
+	ErrorRateLimit
+	// ErrorQuotaExhausted 额度用完
+	ErrorQuotaExhausted
+	// ErrorInvalidKey api key无效或过期
+	ErrorInvalidKey
+	// ErrorContextTooLong 上下文超出模型限制
+	ErrorContextTooLong
+	// ErrorServer 服务端错误或过载
+	ErrorServer
+	// ErrorTimeout 请求超时
+	ErrorTimeout
+	// ErrorCanceled 请求被取消，如用户发送取消口令或者机器人退出
+	ErrorCanceled
+)
+
+// String 错误类型名称
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorRateLimit:
+		return "rate_limit"
+	case ErrorQuotaExhausted:
+		return "quota_exhausted"
+	case ErrorInvalidKey:
+		return "invalid_key"
+	case ErrorContextTooLong:
+		return "context_too_long"
+	case ErrorServer:
+		return "server_error"
+	case ErrorTimeout:
+		return "timeout"
+	case ErrorCanceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+// APIError 接口返回的错误
+type APIError struct {
+	// 错误类型
+	Kind ErrorKind
+	// http状态码
+	StatusCode int
+	// 接口返回的错误信息
+	Type    string
+	Code    string
+	Message string
+	// 接口要求的重试等待时间，来自Retry-After
+	RetryAfter time.Duration
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gpt api error(%s, status %d): %s", e.Kind, e.StatusCode, e.Message)
+}
+
+// Retryable 是否值得重试，频率超限、服务端错误以及超时可以重试
+func (e *APIError) Retryable() bool {
+	return e.Kind == ErrorRateLimit || e.Kind == ErrorServer || e.Kind == ErrorTimeout
+}
+
+// apiErrorBody 接口错误响应体
+type apiErrorBody struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// newAPIError 根据响应状态码、响应头以及响应体生成错误
+func newAPIError(response *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: response.StatusCode, Message: response.Status}
+	errorBody := &apiErrorBody{}
+	if err := json.Unmarshal(body, errorBody); err == nil && errorBody.Error.Message != "" {
+		apiErr.Message = errorBody.Error.Message
+		apiErr.Type = errorBody.Error.Type
+		if errorBody.Error.Code != nil {
+			apiErr.Code = fmt.Sprintf("%v", errorBody.Error.Code)
+		}
+	}
+	apiErr.Kind = classify(apiErr)
+	apiErr.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// classify 根据状态码以及错误码归类
+func classify(e *APIError) ErrorKind {
+	switch {
+	case e.Code == "invalid_api_key" || e.StatusCode == http.StatusUnauthorized:
+		return ErrorInvalidKey
+	case e.Code == "insufficient_quota" || e.Type == "insufficient_quota":
+		return ErrorQuotaExhausted
+	case e.Code == "context_length_exceeded" || strings.Contains(e.Message, "maximum context length"):
+		return ErrorContextTooLong
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case e.StatusCode >= http.StatusInternalServerError || e.Type == "server_error":
+		return ErrorServer
+	default:
+		return ErrorUnknown
+	}
+}
+
+// parseRetryAfter 解析Retry-After，支持秒数和http日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// ErrorKindOf 获取错误类型，网络超时归为ErrorTimeout，ctx取消归为ErrorCanceled
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ErrorUnknown
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorCanceled
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorTimeout
+	}
+	return ErrorUnknown
+}
+
+// retryable 错误是否值得重试，网络错误也重试，配置等其他错误重试也没用
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,109 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// endpoint 备用模型的服务提供方及其凭证
+type endpoint struct {
+	provider   Provider
+	credential Credential
+}
+
+// candidate 对话可以使用的一个模型
+type candidate struct {
+	model string
+	// 为空时使用配置的提供方和key池
+	endpoint *endpoint
+}
+
+// name 日志中显示的名称
+func (c candidate) name() string {
+	if c.endpoint == nil {
+		return c.model
+	}
+	return c.endpoint.provider.Name() + "/" + c.model
+}
+
+// fallbackChain 先用请求的模型（接着写时可能已经是备用模型），再依次使用配置的其他备用模型
+func fallbackChain(first candidate) []candidate {
+	cfg := config.LoadConfig()
+	chain := []candidate{first}
+	for _, fallback := range cfg.Fallbacks {
+		if fallback.Model == "" || (fallback.Provider == "" && fallback.Model == first.model && first.endpoint == nil) {
+			continue
+		}
+		if fallback.Provider == "" {
+			chain = append(chain, candidate{model: fallback.Model})
+			continue
+		}
+		fallbackCfg := *cfg
+		fallbackCfg.Provider = fallback.Provider
+		fallbackCfg.BaseURL = fallback.BaseURL
+		provider, err := NewProvider(&fallbackCfg)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("fallback %s provider error: %v", fallback.Model, err))
+			continue
+		}
+		item := candidate{
+			model:    fallback.Model,
+			endpoint: &endpoint{provider: provider, credential: Credential{Key: fallback.ApiKey}},
+		}
+		if first.endpoint != nil && item.name() == first.name() {
+			continue
+		}
+		chain = append(chain, item)
+	}
+	return chain
+}
+
+// shouldFallback 超时、服务端错误、过载以及网络错误换备用模型，key、上下文等错误换模型也没用
+func shouldFallback(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrorTimeout, ErrorServer, ErrorRateLimit:
+		return true
+	case ErrorCanceled:
+		return false
+	}
+	return retryable(err)
+}
+
+// withFallback 依次使用备用模型发送对话请求，直到成功或者遇到换模型也解决不了的错误，
+// limit为true时除最后一个模型外每个模型最多等待配置的fallback_timeout，流式请求连上之后不能中断，不限制
+func withFallback(ctx context.Context, requestBody ChatCompletionRequestBody, limit bool, do func(ctx context.Context, requestBody ChatCompletionRequestBody) error) error {
+	chain := fallbackChain(candidate{model: requestBody.Model, endpoint: requestBody.endpoint})
+	timeout := time.Second * config.LoadConfig().FallbackTimeout
+	var err error
+	for i, item := range chain {
+		body := requestBody
+		body.Model = item.model
+		body.endpoint = item.endpoint
+		last := i == len(chain)-1
+		if i > 0 {
+			log.Printf("gpt fallback to %s after error: %v\n", item.name(), err)
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if limit && !last && timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err = do(attemptCtx, body)
+		cancel()
+		if err == nil {
+			if i > 0 {
+				log.Printf("gpt answered by fallback %s\n", item.name())
+			}
+			return nil
+		}
+		if last || ctx.Err() != nil || !shouldFallback(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// statusHang 模拟不响应的模型，直到请求被取消
+const statusHang = -1
+
+// useFallbackModels 按模型返回指定的状态码，0表示正常回复，返回按顺序请求过的模型以及每个模型收到的鉴权头
+func useFallbackModels(t *testing.T, statuses map[string]int) (*config.Configuration, func() ([]string, map[string]string)) {
+	var lock sync.Mutex
+	var models []string
+	auth := map[string]string{}
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body ChatCompletionRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		io.Copy(io.Discard, r.Body)
+		lock.Lock()
+		models = append(models, body.Model)
+		auth[body.Model] = r.Header.Get("Authorization")
+		lock.Unlock()
+		switch status := statuses[body.Model]; status {
+		case 0:
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`)
+		case statusHang:
+			<-r.Context().Done()
+		case http.StatusBadRequest:
+			http.Error(w, `{"error":{"message":"too long","code":"context_length_exceeded"}}`, status)
+		default:
+			http.Error(w, `{"error":{"message":"unavailable"}}`, status)
+		}
+	})
+	cfg.Fallbacks = []config.FallbackConfiguration{
+		{Model: "gpt-4"},
+		{Model: "llama3", Provider: ProviderLocal, BaseURL: cfg.BaseURL, ApiKey: "local-key"},
+	}
+	return cfg, func() ([]string, map[string]string) {
+		lock.Lock()
+		defer lock.Unlock()
+		return models, auth
+	}
+}
+
+func TestWithFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     map[string]int
+		wantRequests []string
+		wantAnswered string
+		wantKind     ErrorKind
+	}{
+		{"primary answers", map[string]int{}, []string{testModel}, testModel, ErrorUnknown},
+		{"rate limit", map[string]int{testModel: http.StatusTooManyRequests}, []string{testModel, "gpt-4"}, "gpt-4", ErrorUnknown},
+		{"server error", map[string]int{testModel: http.StatusInternalServerError, "gpt-4": http.StatusServiceUnavailable},
+			[]string{testModel, "gpt-4", "llama3"}, "llama3", ErrorUnknown},
+		{"timeout", map[string]int{testModel: statusHang}, []string{testModel, "gpt-4"}, "gpt-4", ErrorUnknown},
+		{"context too long", map[string]int{testModel: http.StatusBadRequest}, []string{testModel}, "", ErrorContextTooLong},
+		{"fallback context too long", map[string]int{testModel: http.StatusBadGateway, "gpt-4": http.StatusBadRequest},
+			[]string{testModel, "gpt-4"}, "", ErrorContextTooLong},
+		{"all fail", map[string]int{testModel: http.StatusInternalServerError, "gpt-4": http.StatusInternalServerError, "llama3": http.StatusInternalServerError},
+			[]string{testModel, "gpt-4", "llama3"}, "", ErrorServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, requests := useFallbackModels(t, tt.statuses)
+			cfg.FallbackTimeout = 1
+
+			// 每个模型只请求一次，重试由withRetry负责
+			var answered string
+			requestBody := newChatCompletionRequestBody(NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}})
+			err := withFallback(context.Background(), requestBody, true, func(ctx context.Context, body ChatCompletionRequestBody) error {
+				_, err := httpRequestChatCompletions(ctx, body, 1)
+				if err == nil {
+					answered = body.Model
+				}
+				return err
+			})
+			if tt.wantAnswered != "" && err != nil {
+				t.Fatalf("withFallback() error: %v", err)
+			}
+			if tt.wantAnswered == "" && ErrorKindOf(err) != tt.wantKind {
+				t.Fatalf("withFallback() error = %v, want kind %v", err, tt.wantKind)
+			}
+			if answered != tt.wantAnswered {
+				t.Errorf("answered by %q, want %q", answered, tt.wantAnswered)
+			}
+			if models, _ := requests(); fmt.Sprint(models) != fmt.Sprint(tt.wantRequests) {
+				t.Errorf("requested models = %v, want %v", models, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWithFallbackCanceled(t *testing.T) {
+	_, requests := useFallbackModels(t, map[string]int{testModel: statusHang})
+	ctx, cancel := context.WithCancel(context.Background())
+	requestBody := newChatCompletionRequestBody(NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}})
+	err := withFallback(ctx, requestBody, true, func(ctx context.Context, body ChatCompletionRequestBody) error {
+		cancel()
+		_, err := httpRequestChatCompletions(ctx, body, 1)
+		return err
+	})
+	if ErrorKindOf(err) != ErrorCanceled {
+		t.Fatalf("withFallback() error = %v, want canceled", err)
+	}
+	if models, _ := requests(); len(models) > 1 {
+		t.Errorf("requested models = %v, want no fallback after cancel", models)
+	}
+}
+
+func TestChatCompletionsFallbackUsage(t *testing.T) {
+	cfg, requests := useFallbackModels(t, map[string]int{testModel: http.StatusInternalServerError})
+	cfg.Fallbacks = cfg.Fallbacks[1:]
+
+	reply, usage, err := ChatCompletions(context.Background(), NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}})
+	if err != nil || reply != "hello" {
+		t.Fatalf("ChatCompletions() = %q, %v, want hello", reply, err)
+	}
+	// 用量记在实际回答的备用模型上，备用服务使用自己的key
+	if usage.Model != "llama3" || usage.TotalTokens != 2 {
+		t.Errorf("usage = %+v, want 2 tokens on llama3", usage)
+	}
+	if _, auth := requests(); auth["llama3"] != "Bearer local-key" || auth[testModel] != "Bearer test-key" {
+		t.Errorf("auth headers = %v, want the fallback key on llama3", auth)
+	}
+}
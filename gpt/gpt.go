@@ -1,9 +1,8 @@
 package gpt
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -13,14 +12,37 @@ import (
 	"github.com/qingconglaixueit/wechatbot/config"
 )
 
-// ChatGPTResponseBody 请求体
-type ChatGPTResponseBody struct {
-	ID      string                 `json:"id"`
-	Object  string                 `json:"object"`
-	Created int                    `json:"created"`
-	Model   string                 `json:"model"`
-	Choices []ChoiceItem           `json:"choices"`
-	Usage   map[string]interface{} `json:"usage"`
+// defaultRequestTimeout 没有配置request_timeout时单次请求的超时时间
+const defaultRequestTimeout = 60 * time.Second
+
+// 对话角色
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message 对话消息，按角色区分每一轮
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// 模型要求调用的工具，role为assistant时可能有
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// 工具调用结果对应的调用ID，role为tool时必填
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// 文本以外的内容，如图片，有值时content按数组发送
+	Parts []ContentPart `json:"-"`
+}
+
+// ChatCompletionResponseBody 响应体
+type ChatCompletionResponseBody struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int          `json:"created"`
+	Model   string       `json:"model"`
+	Choices []ChoiceItem `json:"choices"`
+	Usage   Usage        `json:"usage"`
 	Error   struct {
 		Message string      `json:"message"`
 		Type    string      `json:"type"`
@@ -30,101 +52,138 @@ type ChatGPTResponseBody struct {
 }
 
 type ChoiceItem struct {
-	Text         string `json:"text"`
-	Index        int    `json:"index"`
-	Logprobs     int    `json:"logprobs"`
-	FinishReason string `json:"finish_reason"`
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
 }
 
-// ChatGPTRequestBody 响应体
-type ChatGPTRequestBody struct {
-	Model            string  `json:"model"`
-	Prompt           string  `json:"prompt"`
-	MaxTokens        uint    `json:"max_tokens"`
-	Temperature      float64 `json:"temperature"`
-	TopP             int     `json:"top_p"`
-	FrequencyPenalty int     `json:"frequency_penalty"`
-	PresencePenalty  int     `json:"presence_penalty"`
+// ChatCompletionRequestBody 请求体
+type ChatCompletionRequestBody struct {
+	Model            string    `json:"model"`
+	Messages         []Message `json:"messages"`
+	MaxTokens        uint      `json:"max_tokens"`
+	Temperature      float64   `json:"temperature"`
+	TopP             int       `json:"top_p"`
+	FrequencyPenalty int       `json:"frequency_penalty"`
+	PresencePenalty  int       `json:"presence_penalty"`
+	Stream           bool      `json:"stream,omitempty"`
+	Tools            []Tool    `json:"tools,omitempty"`
+	// 备用模型的服务，不参与序列化
+	endpoint *endpoint
 }
 
-// Completions gtp文本模型回复
-//curl https://api.openai.com/v1/completions
-//-H "Content-Type: application/json"
-//-H "Authorization: Bearer your chatGPT key"
-//-d '{"model": "text-davinci-003", "prompt": "give me good song", "temperature": 0, "max_tokens": 7}'
-func Completions(msg string) (string, error) {
-	var gptResponseBody *ChatGPTResponseBody
-	var resErr error
-	for retry := 1; retry <= 3; retry++ {
-		if retry > 1 {
-			time.Sleep(time.Duration(retry-1) * 100 * time.Millisecond)
-		}
-		gptResponseBody, resErr = httpRequestCompletions(msg, retry)
-		if resErr != nil {
-			log.Printf("gpt request(%d) error: %v\n", retry, resErr)
-			continue
-		}
-		if gptResponseBody.Error.Message == "" {
-			break
-		}
-	}
-	if resErr != nil {
-		return "", resErr
+// ChatCompletions gtp对话模型回复以及本次用量，回复被截断时自动接着写，接口地址以及鉴权由配置的服务提供方决定
+// curl https://api.openai.com/v1/chat/completions
+// -H "Content-Type: application/json"
+// -H "Authorization: Bearer your chatGPT key"
+// -d '{"model": "gpt-3.5-turbo", "messages": [{"role": "user", "content": "give me good song"}]}'
+func ChatCompletions(ctx context.Context, settings ModelSettings, messages []Message) (string, Usage, error) {
+	requestBody := newChatCompletionRequestBody(settings, messages)
+	gptResponseBody, err := chatCompletions(ctx, &requestBody)
+	if err != nil {
+		return "", Usage{}, err
 	}
-	var reply string
-	if gptResponseBody != nil && len(gptResponseBody.Choices) > 0 {
-		reply = gptResponseBody.Choices[0].Text
+	usage := responseUsage(requestBody, gptResponseBody)
+	if len(gptResponseBody.Choices) == 0 {
+		return "", usage, nil
 	}
-	return reply, nil
+	choice := gptResponseBody.Choices[0]
+	reply := continueCompletions(ctx, requestBody, choice.Message.Content, choice.FinishReason, &usage)
+	return reply, usage, nil
 }
 
-func httpRequestCompletions(msg string, runtimes int) (*ChatGPTResponseBody, error) {
-	cfg := config.LoadConfig()
-	if cfg.ApiKey == "" {
-		return nil, errors.New("api key required")
-	}
+// chatCompletions 发送对话请求，失败时按退避策略重试，仍然失败时换备用模型，
+// 换了备用模型时把requestBody改成实际回答的模型，之后接着写和记用量都按它
+func chatCompletions(ctx context.Context, requestBody *ChatCompletionRequestBody) (*ChatCompletionResponseBody, error) {
+	var gptResponseBody *ChatCompletionResponseBody
+	err := withFallback(ctx, *requestBody, true, func(ctx context.Context, body ChatCompletionRequestBody) error {
+		return withRetry(ctx, "gpt "+body.Model, func(attempt int) error {
+			var err error
+			gptResponseBody, err = httpRequestChatCompletions(ctx, body, attempt)
+			if err == nil {
+				requestBody.Model, requestBody.endpoint = body.Model, body.endpoint
+			}
+			return err
+		})
+	})
+	return gptResponseBody, err
+}
 
-	requestBody := ChatGPTRequestBody{
-		Model:            cfg.Model,
-		Prompt:           msg,
-		MaxTokens:        cfg.MaxTokens,
-		Temperature:      cfg.Temperature,
-		TopP:             1,
-		FrequencyPenalty: 0,
-		PresencePenalty:  0,
-	}
-	requestData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("json.Marshal requestBody error: %v", err)
+// newAPIClient 非流式请求的客户端，每次请求最多等request_timeout，调用方的ctx没有截止时间时也不会一直等下去
+func newAPIClient() *http.Client {
+	timeout := time.Second * config.LoadConfig().RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
 	}
+	return &http.Client{Timeout: timeout}
+}
 
-	log.Printf("gpt request(%d) json: %s\n", runtimes, string(requestData))
-
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/completions", bytes.NewBuffer(requestData))
+func httpRequestChatCompletions(ctx context.Context, requestBody ChatCompletionRequestBody, runtimes int) (*ChatCompletionResponseBody, error) {
+	request, err := newChatCompletionsRequest(requestBody, runtimes)
 	if err != nil {
-		return nil, fmt.Errorf("http.NewRequest error: %v", err)
+		return nil, err
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+cfg.ApiKey)
-	client := &http.Client{Timeout: 15 * time.Second}
-	response, err := client.Do(req)
+	response, err := doRequest(ctx, newAPIClient(), request)
 	if err != nil {
-		return nil, fmt.Errorf("client.Do error: %v", err)
+		return nil, err
 	}
 	defer response.Body.Close()
 
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("ioutil.ReadAll error: %v", err)
+		return nil, fmt.Errorf("ioutil.ReadAll error: %w", err)
 	}
 
 	log.Printf("gpt response(%d) json: %s\n", runtimes, string(body))
 
-	gptResponseBody := &ChatGPTResponseBody{}
+	gptResponseBody := &ChatCompletionResponseBody{}
 	err = json.Unmarshal(body, gptResponseBody)
 	if err != nil {
-		return nil, fmt.Errorf("json.Marshal responseBody error: %v", err)
+		return nil, fmt.Errorf("json.Unmarshal responseBody error: %v", err)
+	}
+	if gptResponseBody.Error.Message != "" {
+		return nil, newAPIError(response, body)
 	}
 	return gptResponseBody, nil
 }
+
+// newChatCompletionRequestBody 按选择的模型参数创建对话请求体，带图片时使用识图模型
+func newChatCompletionRequestBody(settings ModelSettings, messages []Message) ChatCompletionRequestBody {
+	model := settings.Model
+	if visionModel := config.LoadConfig().VisionModel; hasImage(messages) && visionModel != "" {
+		model = visionModel
+	}
+	return ChatCompletionRequestBody{
+		Model:            model,
+		Messages:         messages,
+		MaxTokens:        settings.MaxTokens,
+		Temperature:      settings.Temperature,
+		TopP:             1,
+		FrequencyPenalty: 0,
+		PresencePenalty:  0,
+	}
+}
+
+// newChatCompletionsRequest 组装对话接口请求
+func newChatCompletionsRequest(requestBody ChatCompletionRequestBody, runtimes int) (apiRequest, error) {
+	requestData, err := json.Marshal(requestBody)
+	if err != nil {
+		return apiRequest{}, fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+
+	log.Printf("gpt request(%d) %s json: %s\n", runtimes, config.LoadConfig().Provider, string(requestData))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if requestBody.Stream {
+		header.Set("Accept", "text/event-stream")
+	}
+	return apiRequest{
+		method:   http.MethodPost,
+		path:     "/chat/completions",
+		model:    requestBody.Model,
+		header:   header,
+		body:     requestData,
+		endpoint: requestBody.endpoint,
+	}, nil
+}
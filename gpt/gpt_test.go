@@ -0,0 +1,51 @@
+package gpt
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewAPIClient(t *testing.T) {
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		requestTimeout time.Duration
+		want           time.Duration
+	}{
+		{30, 30 * time.Second},
+		{0, defaultRequestTimeout},
+	}
+	for _, tt := range tests {
+		cfg.RequestTimeout = tt.requestTimeout
+		if got := newAPIClient().Timeout; got != tt.want {
+			t.Errorf("request_timeout %d: client timeout = %v, want %v", tt.requestTimeout, got, tt.want)
+		}
+	}
+}
+
+func TestChatCompletionsStalledResponse(t *testing.T) {
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// 发出响应头后不再发送响应体
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	cfg.RequestTimeout = 1
+
+	// ctx没有截止时间，由客户端的超时中断
+	requestBody := newChatCompletionRequestBody(NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}})
+	done := make(chan error, 1)
+	go func() {
+		_, err := httpRequestChatCompletions(context.Background(), requestBody, 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if ErrorKindOf(err) != ErrorTimeout {
+			t.Fatalf("stalled response error = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled response did not time out")
+	}
+}
@@ -0,0 +1,99 @@
+package gpt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// ImageRequestBody 图片生成请求体
+type ImageRequestBody struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	ResponseFormat string `json:"response_format"`
+}
+
+// ImageResponseBody 图片生成响应体
+type ImageResponseBody struct {
+	Created int64       `json:"created"`
+	Data    []ImageItem `json:"data"`
+}
+
+type ImageItem struct {
+	URL           string `json:"url"`
+	B64JSON       string `json:"b64_json"`
+	RevisedPrompt string `json:"revised_prompt"`
+}
+
+// GenerateImages 根据描述生成图片，返回图片内容（PNG）
+// curl https://api.openai.com/v1/images/generations
+// -H "Content-Type: application/json"
+// -H "Authorization: Bearer your chatGPT key"
+// -d '{"prompt": "a white siamese cat", "n": 1, "size": "1024x1024", "response_format": "b64_json"}'
+func GenerateImages(ctx context.Context, prompt string, n int, size string) ([][]byte, error) {
+	cfg := config.LoadConfig()
+	requestBody := ImageRequestBody{
+		Model:          cfg.ImageModel,
+		Prompt:         prompt,
+		N:              n,
+		Size:           size,
+		ResponseFormat: "b64_json",
+	}
+	requestData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+	log.Printf("gpt image request json: %s\n", string(requestData))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	request := apiRequest{
+		method: http.MethodPost,
+		path:   "/images/generations",
+		model:  cfg.ImageModel,
+		header: header,
+		body:   requestData,
+	}
+
+	var images [][]byte
+	err = withRetry(ctx, "gpt image", func(attempt int) error {
+		response, err := doRequest(ctx, http.DefaultClient, request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("ioutil.ReadAll error: %w", err)
+		}
+		imageResponseBody := &ImageResponseBody{}
+		if err := json.Unmarshal(body, imageResponseBody); err != nil {
+			return fmt.Errorf("json.Unmarshal responseBody error: %v", err)
+		}
+		images = images[:0]
+		for _, item := range imageResponseBody.Data {
+			data, err := base64.StdEncoding.DecodeString(item.B64JSON)
+			if err != nil {
+				return fmt.Errorf("decode b64_json error: %v", err)
+			}
+			images = append(images, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, errors.New("gpt image response without data")
+	}
+	return images, nil
+}
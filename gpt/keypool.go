@@ -0,0 +1,235 @@
+package gpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// key选择策略
+const (
+	KeyStrategyRoundRobin = "round_robin"
+	KeyStrategyLeastUsed  = "least_used"
+)
+
+// Credential 请求使用的凭证
+type Credential struct {
+	Key          string
+	Organization string
+}
+
+// apiKey key池中的一个key
+type apiKey struct {
+	Credential
+	// 权重，越大分到的请求越多
+	weight int
+	// 平滑加权轮询的当前值
+	current int
+	// 已使用次数
+	used uint64
+	// 是否被隔离，以及隔离原因和时间
+	quarantined   bool
+	reason        ErrorKind
+	quarantinedAt time.Time
+}
+
+// KeyPool api key池，按策略选择key，key无效或额度用完时自动隔离，并定期探测恢复
+type KeyPool struct {
+	lock     sync.Mutex
+	keys     []*apiKey
+	strategy string
+}
+
+var (
+	keyPool     *KeyPool
+	keyPoolOnce sync.Once
+)
+
+// defaultKeyPool 根据配置创建全局key池，并启动隔离key的探测
+func defaultKeyPool() *KeyPool {
+	keyPoolOnce.Do(func() {
+		cfg := config.LoadConfig()
+		keyPool = NewKeyPool(cfg)
+		if cfg.KeyProbeInterval > 0 {
+			go keyPool.probeLoop(time.Second * cfg.KeyProbeInterval)
+		}
+	})
+	return keyPool
+}
+
+// NewKeyPool 创建key池，配置了api_keys时使用api_keys，否则使用api_key
+func NewKeyPool(cfg *config.Configuration) *KeyPool {
+	pool := &KeyPool{strategy: cfg.KeyStrategy}
+	for _, item := range cfg.ApiKeys {
+		if item.Key == "" {
+			continue
+		}
+		weight := item.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.keys = append(pool.keys, &apiKey{
+			Credential: Credential{Key: item.Key, Organization: item.Organization},
+			weight:     weight,
+		})
+	}
+	if len(pool.keys) == 0 {
+		// 兼容OpenAI接口的本地服务可以不配置key，这里保留一个空key
+		pool.keys = append(pool.keys, &apiKey{Credential: Credential{Key: cfg.ApiKey}, weight: 1})
+	}
+	return pool
+}
+
+// Acquire 按策略选择一个可用的key，所有key都被隔离时返回最后一次隔离原因对应的错误
+func (p *KeyPool) Acquire() (*apiKey, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var (
+		best  *apiKey
+		total int
+	)
+	for _, key := range p.keys {
+		if key.quarantined {
+			continue
+		}
+		switch p.strategy {
+		case KeyStrategyLeastUsed:
+			// 使用次数按权重折算，最少的优先
+			if best == nil || key.used*uint64(best.weight) < best.used*uint64(key.weight) {
+				best = key
+			}
+		default:
+			// 平滑加权轮询
+			key.current += key.weight
+			total += key.weight
+			if best == nil || key.current > best.current {
+				best = key
+			}
+		}
+	}
+	if best == nil {
+		return nil, p.unavailableError()
+	}
+	if p.strategy != KeyStrategyLeastUsed {
+		best.current -= total
+	}
+	best.used++
+	return best, nil
+}
+
+// Report 上报key的请求错误，key无效或额度用完时隔离该key，返回是否还有其他可用key可以换着重试
+func (p *KeyPool) Report(key *apiKey, err error) bool {
+	kind := ErrorKindOf(err)
+	if kind != ErrorInvalidKey && kind != ErrorQuotaExhausted {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !key.quarantined {
+		key.quarantined = true
+		key.reason = kind
+		key.quarantinedAt = time.Now()
+		logger.Warning(fmt.Sprintf("api key %s quarantined: %v", maskKey(key.Key), err))
+	}
+	for _, item := range p.keys {
+		if !item.quarantined {
+			return true
+		}
+	}
+	return false
+}
+
+// unavailableError 没有可用key时的错误，使用最近一次隔离的原因
+func (p *KeyPool) unavailableError() error {
+	var last *apiKey
+	for _, key := range p.keys {
+		if last == nil || key.quarantinedAt.After(last.quarantinedAt) {
+			last = key
+		}
+	}
+	apiErr := &APIError{Kind: ErrorInvalidKey, Message: "no available api key"}
+	if last != nil {
+		apiErr.Kind = last.reason
+	}
+	return apiErr
+}
+
+// probeLoop 定期探测被隔离的key，恢复已经可以正常请求的key
+func (p *KeyPool) probeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.lock.Lock()
+		var quarantined []*apiKey
+		for _, key := range p.keys {
+			if key.quarantined && time.Since(key.quarantinedAt) >= interval {
+				quarantined = append(quarantined, key)
+			}
+		}
+		p.lock.Unlock()
+
+		for _, key := range quarantined {
+			if err := probeKey(key.Credential); err != nil {
+				logger.Info(fmt.Sprintf("api key %s probe failed: %v", maskKey(key.Key), err))
+				p.lock.Lock()
+				key.quarantinedAt = time.Now()
+				p.lock.Unlock()
+				continue
+			}
+			p.lock.Lock()
+			key.quarantined = false
+			p.lock.Unlock()
+			logger.Info(fmt.Sprintf("api key %s restored", maskKey(key.Key)))
+		}
+	}
+}
+
+// probeKey 用一个只生成1个token的对话请求探测key，额度用完的key查询模型列表仍然成功，所以不能只查模型列表
+func probeKey(credential Credential) error {
+	cfg := config.LoadConfig()
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	requestData, err := json.Marshal(ChatCompletionRequestBody{
+		Model:     cfg.Model,
+		Messages:  []Message{{Role: RoleUser, Content: "ping"}},
+		MaxTokens: 1,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := provider.NewRequest(ctx, credential, http.MethodPost, "/chat/completions", cfg.Model, bytes.NewReader(requestData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %s", response.Status)
+	}
+	return nil
+}
+
+// maskKey 日志中只显示key的首尾
+func maskKey(key string) string {
+	if len(key) <= 10 {
+		return "***"
+	}
+	return key[:6] + "***" + key[len(key)-4:]
+}
@@ -0,0 +1,107 @@
+package gpt
+
+import (
+	"testing"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// newTestPool 创建指定策略和权重的key池，key依次为k0、k1……
+func newTestPool(strategy string, weights ...int) *KeyPool {
+	cfg := &config.Configuration{KeyStrategy: strategy}
+	for i, weight := range weights {
+		cfg.ApiKeys = append(cfg.ApiKeys, config.ApiKeyConfiguration{Key: "k" + string(rune('0'+i)), Weight: weight})
+	}
+	return NewKeyPool(cfg)
+}
+
+// acquireKeys 连续选择n次，返回每个key被选中的次数
+func acquireKeys(t *testing.T, pool *KeyPool, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		key, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire error: %v", err)
+		}
+		counts[key.Key]++
+	}
+	return counts
+}
+
+func TestNewKeyPool(t *testing.T) {
+	pool := NewKeyPool(&config.Configuration{ApiKey: "single"})
+	if len(pool.keys) != 1 || pool.keys[0].Key != "single" {
+		t.Fatalf("pool without api_keys = %+v, want single api_key", pool.keys)
+	}
+	pool = NewKeyPool(&config.Configuration{ApiKey: "single", ApiKeys: []config.ApiKeyConfiguration{{Key: ""}, {Key: "a", Weight: -1}}})
+	if len(pool.keys) != 1 || pool.keys[0].Key != "a" || pool.keys[0].weight != 1 {
+		t.Fatalf("pool with api_keys = %+v, want key a with weight 1", pool.keys[0])
+	}
+}
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+	pool := newTestPool(KeyStrategyRoundRobin, 3, 1)
+	counts := acquireKeys(t, pool, 8)
+	if counts["k0"] != 6 || counts["k1"] != 2 {
+		t.Fatalf("weighted round robin = %v, want k0:6 k1:2", counts)
+	}
+
+	// 平滑加权轮询不会连续把同一个key用完再换
+	pool = newTestPool(KeyStrategyRoundRobin, 1, 1)
+	first, _ := pool.Acquire()
+	second, _ := pool.Acquire()
+	if first == second {
+		t.Fatalf("equal weights picked %s twice in a row", first.Key)
+	}
+}
+
+func TestKeyPoolLeastUsed(t *testing.T) {
+	pool := newTestPool(KeyStrategyLeastUsed, 1, 1, 2)
+	counts := acquireKeys(t, pool, 8)
+	if counts["k0"] != 2 || counts["k1"] != 2 || counts["k2"] != 4 {
+		t.Fatalf("least used = %v, want k0:2 k1:2 k2:4", counts)
+	}
+}
+
+func TestKeyPoolQuarantine(t *testing.T) {
+	pool := newTestPool(KeyStrategyRoundRobin, 1, 1)
+	k0, _ := pool.Acquire()
+
+	// 可重试的错误不隔离
+	if pool.Report(k0, &APIError{Kind: ErrorRateLimit}) {
+		t.Fatal("Report(rate limit) asked to switch keys")
+	}
+	if k0.quarantined {
+		t.Fatal("rate limited key was quarantined")
+	}
+
+	// key无效时隔离，还有其他key可以换
+	if !pool.Report(k0, &APIError{Kind: ErrorInvalidKey}) {
+		t.Fatal("Report(invalid key) with another key left returned false")
+	}
+	counts := acquireKeys(t, pool, 4)
+	if counts[k0.Key] != 0 {
+		t.Fatalf("quarantined key %s was still acquired: %v", k0.Key, counts)
+	}
+
+	// 全部隔离后返回最后一次隔离的原因
+	k1, _ := pool.Acquire()
+	if pool.Report(k1, &APIError{Kind: ErrorQuotaExhausted}) {
+		t.Fatal("Report with no keys left returned true")
+	}
+	if _, err := pool.Acquire(); ErrorKindOf(err) != ErrorQuotaExhausted {
+		t.Fatalf("Acquire with all keys quarantined = %v, want quota exhausted", err)
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	tests := map[string]string{
+		"short":                "***",
+		"sk-1234567890abcdefg": "sk-123***defg",
+	}
+	for key, want := range tests {
+		if got := maskKey(key); got != want {
+			t.Errorf("maskKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package gpt
+
+import (
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// ModelSettings 一次对话使用的模型以及参数
+type ModelSettings struct {
+	Model       string
+	MaxTokens   uint
+	Temperature float64
+}
+
+// NewModelSettings 按配置获取模型的参数，models中没有单独配置的参数使用全局值，model为空时使用默认模型
+func NewModelSettings(model string) ModelSettings {
+	cfg := config.LoadConfig()
+	if model == "" {
+		model = cfg.Model
+	}
+	settings := ModelSettings{Model: model, MaxTokens: cfg.MaxTokens, Temperature: cfg.Temperature}
+	if modelCfg, ok := cfg.Models[model]; ok {
+		if modelCfg.MaxTokens > 0 {
+			settings.MaxTokens = modelCfg.MaxTokens
+		}
+		if modelCfg.Temperature != nil {
+			settings.Temperature = *modelCfg.Temperature
+		}
+	}
+	return settings
+}
+
+// ModelAllowed 是否为可以使用的模型：默认模型或models中配置的模型
+func ModelAllowed(model string) bool {
+	cfg := config.LoadConfig()
+	if model == cfg.Model {
+		return true
+	}
+	_, ok := cfg.Models[model]
+	return ok
+}
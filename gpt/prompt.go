@@ -0,0 +1,54 @@
+package gpt
+
+// PromptBudget 请求可用于提问和上下文的token数：模型上下文窗口减去回复预留的MaxTokens
+func PromptBudget(model string, maxTokens uint) int {
+	return ContextWindow(model) - int(maxTokens)
+}
+
+// BuildPrompt 组装请求消息，history开头的system消息始终保留，
+// 其余历史从最新的一轮往前尽量多地保留，使总token不超过预算，放不下的提问按token截断
+func BuildPrompt(model string, maxTokens uint, history []Message, question Message) []Message {
+	budget := PromptBudget(model, maxTokens)
+
+	// 1.拆出固定保留的system消息
+	var pinned []Message
+	for len(history) > 0 && history[0].Role == RoleSystem {
+		pinned = append(pinned, history[0])
+		history = history[1:]
+	}
+
+	// 2.system消息和提问优先占用预算，提问超出时截断
+	used := CountMessagesTokens(model, pinned)
+	questionTokens := CountMessageTokens(model, question)
+	if used+questionTokens > budget {
+		question.Content = TruncateTokens(model, question.Content, budget-used-CountMessageTokens(model, Message{Role: question.Role, Parts: question.Parts}))
+		questionTokens = CountMessageTokens(model, question)
+	}
+	used += questionTokens
+
+	// 3.从最新的历史消息往前保留
+	kept := TrimMessages(model, history, budget-used)
+
+	messages := make([]Message, 0, len(pinned)+len(kept)+1)
+	messages = append(messages, pinned...)
+	messages = append(messages, kept...)
+	return append(messages, question)
+}
+
+// TrimMessages 从最新的消息往前保留，使消息token总数不超过budget，保留部分不以assistant回复开头
+func TrimMessages(model string, messages []Message, budget int) []Message {
+	start := len(messages)
+	used := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		tokens := CountMessageTokens(model, messages[i])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start = i
+	}
+	for start < len(messages) && messages[start].Role == RoleAssistant {
+		start++
+	}
+	return messages[start:]
+}
@@ -0,0 +1,74 @@
+package gpt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// alternating 按顺序生成user和assistant交替的消息
+func alternating(contents ...string) []Message {
+	messages := make([]Message, 0, len(contents))
+	for i, content := range contents {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+	return messages
+}
+
+func TestTrimMessages(t *testing.T) {
+	history := alternating("q1", "a1", "q2", "a2")
+	size := CountMessageTokens(testModel, history[0])
+	tests := []struct {
+		name   string
+		budget int
+		want   []Message
+	}{
+		{"all fit", 4 * size, history},
+		{"keep newest", 2 * size, history[2:]},
+		// 只放得下最后一条回复时不能以assistant开头
+		{"no leading assistant", size, history[4:]},
+		{"nothing fits", 0, history[4:]},
+	}
+	for _, tt := range tests {
+		if got := TrimMessages(testModel, history, tt.budget); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: TrimMessages = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	system := Message{Role: RoleSystem, Content: "你是翻译"}
+	history := append([]Message{system}, alternating("q1", "a1", "q2", "a2")...)
+	question := Message{Role: RoleUser, Content: "q3"}
+	window := ContextWindow(testModel)
+
+	// 预算足够时全部保留，system在最前，提问在最后
+	got := BuildPrompt(testModel, 512, history, question)
+	want := append(append([]Message{}, history...), question)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("BuildPrompt = %v, want %v", got, want)
+	}
+
+	// 预算只够system、提问和最近一轮
+	size := CountMessageTokens(testModel, history[1])
+	budget := CountMessagesTokens(testModel, []Message{system}) + CountMessageTokens(testModel, question) + 2*size
+	got = BuildPrompt(testModel, uint(window-budget), history, question)
+	want = []Message{system, history[3], history[4], question}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("BuildPrompt with small budget = %v, want %v", got, want)
+	}
+
+	// 提问超出预算时截断提问，不保留历史
+	long := Message{Role: RoleUser, Content: strings.Repeat("hello ", 5000)}
+	got = BuildPrompt(testModel, 512, history, long)
+	if len(got) != 2 || !reflect.DeepEqual(got[0], system) || got[1].Content == long.Content {
+		t.Fatalf("BuildPrompt with long question kept %d messages", len(got))
+	}
+	if tokens := CountMessagesTokens(testModel, got); tokens > PromptBudget(testModel, 512) {
+		t.Fatalf("BuildPrompt with long question used %d tokens, budget %d", tokens, PromptBudget(testModel, 512))
+	}
+}
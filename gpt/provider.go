@@ -0,0 +1,129 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// 支持的大模型服务提供方
+const (
+	ProviderOpenAI = "openai"
+	ProviderAzure  = "azure"
+	ProviderLocal  = "local"
+)
+
+const (
+	defaultOpenAIBaseURL   = "https://api.openai.com/v1"
+	defaultAzureAPIVersion = "2023-05-15"
+)
+
+// Provider 大模型服务提供方，负责拼接接口地址以及鉴权
+type Provider interface {
+	// Name 提供方名称
+	Name() string
+	// NewRequest 使用指定凭证创建接口请求，path为相对OpenAI /v1 的路径，如 /chat/completions
+	NewRequest(ctx context.Context, credential Credential, method, path, model string, body io.Reader) (*http.Request, error)
+}
+
+var (
+	_ Provider = (*openAIProvider)(nil)
+	_ Provider = (*azureProvider)(nil)
+)
+
+// NewProvider 根据配置创建服务提供方，api key由key池在每次请求时提供
+func NewProvider(cfg *config.Configuration) (Provider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", ProviderOpenAI:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		return &openAIProvider{name: ProviderOpenAI, baseURL: baseURL}, nil
+	case ProviderLocal:
+		// 兼容OpenAI接口的本地服务（llama.cpp、Ollama等），api key可以不填
+		if cfg.BaseURL == "" {
+			return nil, errors.New("base url required for local provider")
+		}
+		return &openAIProvider{name: ProviderLocal, baseURL: cfg.BaseURL}, nil
+	case ProviderAzure:
+		if cfg.Azure.Endpoint == "" {
+			return nil, errors.New("azure endpoint required")
+		}
+		apiVersion := cfg.Azure.APIVersion
+		if apiVersion == "" {
+			apiVersion = defaultAzureAPIVersion
+		}
+		return &azureProvider{
+			endpoint:    cfg.Azure.Endpoint,
+			apiVersion:  apiVersion,
+			deployments: cfg.Azure.Deployments,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", cfg.Provider)
+	}
+}
+
+// openAIProvider OpenAI官方接口以及兼容OpenAI接口的服务
+type openAIProvider struct {
+	name    string
+	baseURL string
+}
+
+// Name 提供方名称
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+// NewRequest 创建接口请求，使用 Bearer 鉴权
+func (p *openAIProvider) NewRequest(ctx context.Context, credential Credential, method, path, model string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(p.baseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+credential.Key)
+	}
+	if credential.Organization != "" {
+		req.Header.Set("OpenAI-Organization", credential.Organization)
+	}
+	return req, nil
+}
+
+// azureProvider Azure OpenAI 接口，按模型映射部署名称
+type azureProvider struct {
+	endpoint    string
+	apiVersion  string
+	deployments map[string]string
+}
+
+// Name 提供方名称
+func (p *azureProvider) Name() string {
+	return ProviderAzure
+}
+
+// NewRequest 创建接口请求，地址为 {endpoint}/openai/deployments/{deployment}{path}?api-version=，使用 api-key 鉴权
+func (p *azureProvider) NewRequest(ctx context.Context, credential Credential, method, path, model string, body io.Reader) (*http.Request, error) {
+	requestURL := fmt.Sprintf("%s/openai/deployments/%s%s?api-version=%s", strings.TrimRight(p.endpoint, "/"),
+		url.PathEscape(p.deployment(model)), path, url.QueryEscape(p.apiVersion))
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("api-key", credential.Key)
+	return req, nil
+}
+
+// deployment 获取模型对应的部署名称，未配置时去掉模型名中的点号（Azure部署名不允许点号，如gpt-35-turbo）
+func (p *azureProvider) deployment(model string) string {
+	if name, ok := p.deployments[model]; ok {
+		return name
+	}
+	return strings.ReplaceAll(model, ".", "")
+}
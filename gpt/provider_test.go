@@ -0,0 +1,135 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Configuration
+		want    string
+		wantErr bool
+	}{
+		{"default", config.Configuration{}, ProviderOpenAI, false},
+		{"openai", config.Configuration{Provider: "OpenAI"}, ProviderOpenAI, false},
+		{"local", config.Configuration{Provider: ProviderLocal, BaseURL: "http://127.0.0.1:11434/v1"}, ProviderLocal, false},
+		{"local without base url", config.Configuration{Provider: ProviderLocal}, "", true},
+		{"azure", config.Configuration{Provider: ProviderAzure, Azure: config.AzureConfiguration{Endpoint: "https://x.openai.azure.com"}}, ProviderAzure, false},
+		{"azure without endpoint", config.Configuration{Provider: ProviderAzure}, "", true},
+		{"unknown", config.Configuration{Provider: "other"}, "", true},
+	}
+	for _, tt := range tests {
+		provider, err := NewProvider(&tt.cfg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: NewProvider() = %s, want an error", tt.name, provider.Name())
+			}
+			continue
+		}
+		if err != nil || provider.Name() != tt.want {
+			t.Errorf("%s: NewProvider() = %v, %v, want %s", tt.name, provider, err, tt.want)
+		}
+	}
+}
+
+func TestProviderRequest(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, `{"choices":[]}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		cfg        config.Configuration
+		credential Credential
+		model      string
+		wantURL    string
+		wantHeader http.Header
+	}{
+		{
+			name:       "openai",
+			cfg:        config.Configuration{Provider: ProviderOpenAI, BaseURL: server.URL + "/v1/"},
+			credential: Credential{Key: "sk-1", Organization: "org-1"},
+			model:      "gpt-3.5-turbo",
+			wantURL:    "/v1/chat/completions",
+			wantHeader: http.Header{"Authorization": {"Bearer sk-1"}, "Openai-Organization": {"org-1"}},
+		},
+		{
+			name:       "local without key",
+			cfg:        config.Configuration{Provider: ProviderLocal, BaseURL: server.URL + "/v1"},
+			model:      "llama3",
+			wantURL:    "/v1/chat/completions",
+			wantHeader: http.Header{"Authorization": nil, "Api-Key": nil},
+		},
+		{
+			name: "azure deployment",
+			cfg: config.Configuration{Provider: ProviderAzure, Azure: config.AzureConfiguration{
+				Endpoint: server.URL + "/", APIVersion: "2024-02-01", Deployments: map[string]string{"gpt-4": "my-gpt4"},
+			}},
+			credential: Credential{Key: "azure-key"},
+			model:      "gpt-4",
+			wantURL:    "/openai/deployments/my-gpt4/chat/completions?api-version=2024-02-01",
+			wantHeader: http.Header{"Api-Key": {"azure-key"}, "Authorization": nil},
+		},
+		{
+			name:       "azure model without deployment",
+			cfg:        config.Configuration{Provider: ProviderAzure, Azure: config.AzureConfiguration{Endpoint: server.URL}},
+			credential: Credential{Key: "azure-key"},
+			model:      "gpt-3.5-turbo",
+			wantURL:    "/openai/deployments/gpt-35-turbo/chat/completions?api-version=" + defaultAzureAPIVersion,
+			wantHeader: http.Header{"Api-Key": {"azure-key"}},
+		},
+	}
+	for _, tt := range tests {
+		provider, err := NewProvider(&tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: NewProvider() error: %v", tt.name, err)
+		}
+		request, err := newChatCompletionsRequest(newChatCompletionRequestBody(NewModelSettings(tt.model), []Message{{Role: RoleUser, Content: "hi"}}), 1)
+		if err != nil {
+			t.Fatalf("%s: newChatCompletionsRequest() error: %v", tt.name, err)
+		}
+		response, err := send(context.Background(), server.Client(), provider, tt.credential, request)
+		if err != nil {
+			t.Fatalf("%s: send() error: %v", tt.name, err)
+		}
+		response.Body.Close()
+
+		if url := got.URL.RequestURI(); url != tt.wantURL {
+			t.Errorf("%s: request url = %s, want %s", tt.name, url, tt.wantURL)
+		}
+		for name, want := range tt.wantHeader {
+			if value := got.Header.Get(name); (want == nil && value != "") || (want != nil && value != want[0]) {
+				t.Errorf("%s: header %s = %q, want %q", tt.name, name, value, want)
+			}
+		}
+	}
+}
+
+func TestChatCompletionsAzure(t *testing.T) {
+	var got *http.Request
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}]}`)
+	})
+	cfg.Provider = ProviderAzure
+	cfg.Azure = config.AzureConfiguration{Endpoint: cfg.BaseURL, Deployments: map[string]string{testModel: "chat"}}
+
+	// 配置的提供方和key池走完整的对话请求
+	reply, _, err := ChatCompletions(context.Background(), NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}})
+	if err != nil || reply != "hello" {
+		t.Fatalf("ChatCompletions() = %q, %v, want hello", reply, err)
+	}
+	if got.URL.Path != "/openai/deployments/chat/chat/completions" || got.Header.Get("api-key") != "test-key" {
+		t.Errorf("azure request = %s api-key %q, want the chat deployment with the pool key", got.URL.Path, got.Header.Get("api-key"))
+	}
+}
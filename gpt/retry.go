@@ -0,0 +1,65 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	// maxAttempts 最多请求次数
+	maxAttempts = 3
+	// backoffBase 第一次重试前的基础等待时间
+	backoffBase = 500 * time.Millisecond
+	// backoffMax 单次等待上限
+	backoffMax = 20 * time.Second
+)
+
+// withRetry 执行请求，可重试的错误按指数退避加随机抖动重试，接口返回Retry-After时以其为准，ctx结束时停止重试
+func withRetry(ctx context.Context, name string, do func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			wait := backoff(attempt-1, err)
+			log.Printf("%s retry(%d) after %v\n", name, attempt, wait)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err = do(attempt)
+		if err == nil {
+			return nil
+		}
+		log.Printf("%s request(%d) error: %v\n", name, attempt, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff 第n次重试前的等待时间
+func backoff(retry int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > backoffMax {
+			return backoffMax
+		}
+		return apiErr.RetryAfter
+	}
+	wait := backoffBase << uint(retry-1)
+	if wait > backoffMax {
+		wait = backoffMax
+	}
+	// 抖动：在 [wait/2, wait) 之间随机，避免多个请求同时重试
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
+}
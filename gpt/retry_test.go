@@ -0,0 +1,122 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for retry := 1; retry <= 8; retry++ {
+		wait := backoffBase << uint(retry-1)
+		if wait > backoffMax {
+			wait = backoffMax
+		}
+		for i := 0; i < 20; i++ {
+			got := backoff(retry, errors.New("timeout"))
+			if got < wait/2 || got >= wait {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v)", retry, got, wait/2, wait)
+			}
+		}
+	}
+
+	// 接口给了Retry-After时以其为准，但不超过上限
+	if got := backoff(1, &APIError{Kind: ErrorRateLimit, RetryAfter: 3 * time.Second}); got != 3*time.Second {
+		t.Errorf("backoff with Retry-After 3s = %v", got)
+	}
+	if got := backoff(1, &APIError{Kind: ErrorRateLimit, RetryAfter: time.Hour}); got != backoffMax {
+		t.Errorf("backoff with Retry-After 1h = %v, want %v", got, backoffMax)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	retryLater := &APIError{Kind: ErrorServer, RetryAfter: time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error
+		wantErr  error
+		attempts int
+	}{
+		{"success", []error{nil}, nil, 1},
+		{"retry then success", []error{retryLater, nil}, nil, 2},
+		{"give up after max attempts", []error{retryLater, retryLater, retryLater, nil}, retryLater, maxAttempts},
+		{"not retryable", []error{&APIError{Kind: ErrorInvalidKey}}, nil, 1},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		err := withRetry(context.Background(), "test", func(attempt int) error {
+			attempts++
+			if attempt != attempts {
+				t.Errorf("%s: attempt = %d, want %d", tt.name, attempt, attempts)
+			}
+			return tt.errs[attempt-1]
+		})
+		if attempts != tt.attempts {
+			t.Errorf("%s: attempts = %d, want %d", tt.name, attempts, tt.attempts)
+		}
+		if tt.wantErr != nil && err != tt.wantErr {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.name == "not retryable" && ErrorKindOf(err) != ErrorInvalidKey {
+			t.Errorf("%s: err = %v, want invalid key", tt.name, err)
+		}
+	}
+}
+
+func TestWithRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := withRetry(ctx, "test", func(int) error {
+		attempts++
+		cancel()
+		return &APIError{Kind: ErrorServer}
+	})
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Fatalf("withRetry after cancel = %v after %d attempts, want context.Canceled after 1", err, attempts)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  APIError
+		want ErrorKind
+	}{
+		{APIError{StatusCode: http.StatusUnauthorized}, ErrorInvalidKey},
+		{APIError{StatusCode: http.StatusBadRequest, Code: "invalid_api_key"}, ErrorInvalidKey},
+		{APIError{StatusCode: http.StatusTooManyRequests, Code: "insufficient_quota"}, ErrorQuotaExhausted},
+		{APIError{StatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 4097 tokens"}, ErrorContextTooLong},
+		{APIError{StatusCode: http.StatusTooManyRequests}, ErrorRateLimit},
+		{APIError{StatusCode: http.StatusBadGateway}, ErrorServer},
+		{APIError{StatusCode: http.StatusBadRequest}, ErrorUnknown},
+	}
+	for _, tt := range tests {
+		if got := classify(&tt.err); got != tt.want {
+			t.Errorf("classify(%+v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"2", 2 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	future := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if future <= 0 || future > time.Minute {
+		t.Errorf("parseRetryAfter(http date in 1m) = %v", future)
+	}
+}
@@ -0,0 +1,74 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// SpeechRequestBody 语音合成请求体
+type SpeechRequestBody struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// Speech 文字转语音，返回mp3音频
+// curl https://api.openai.com/v1/audio/speech
+// -H "Content-Type: application/json"
+// -H "Authorization: Bearer your chatGPT key"
+// -d '{"model": "tts-1", "input": "你好", "voice": "alloy", "response_format": "mp3"}'
+func Speech(ctx context.Context, text string) ([]byte, error) {
+	cfg := config.LoadConfig()
+	requestBody := SpeechRequestBody{
+		Model:          cfg.SpeechModel,
+		Input:          text,
+		Voice:          cfg.SpeechVoice,
+		ResponseFormat: "mp3",
+		Speed:          cfg.SpeechSpeed,
+	}
+	requestData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal requestBody error: %v", err)
+	}
+	log.Printf("gpt speech request json: %s\n", string(requestData))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	request := apiRequest{
+		method: http.MethodPost,
+		path:   "/audio/speech",
+		model:  cfg.SpeechModel,
+		header: header,
+		body:   requestData,
+	}
+
+	var audio []byte
+	err = withRetry(ctx, "gpt speech", func(attempt int) error {
+		response, err := doRequest(ctx, http.DefaultClient, request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		audio, err = ioutil.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("ioutil.ReadAll error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(audio) == 0 {
+		return nil, errors.New("gpt speech response without audio")
+	}
+	return audio, nil
+}
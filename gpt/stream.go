@@ -0,0 +1,210 @@
+package gpt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// ChatCompletionStreamResponseBody 流式响应体，每个data事件一份
+type ChatCompletionStreamResponseBody struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int                `json:"created"`
+	Model   string             `json:"model"`
+	Choices []StreamChoiceItem `json:"choices"`
+	// 部分服务在最后一个事件返回用量
+	Usage *Usage `json:"usage"`
+}
+
+type StreamChoiceItem struct {
+	Index        int     `json:"index"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// streamClient 流式请求不设置整体超时，只限制等待响应头的时间
+var streamClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+}
+
+// ChatCompletionsStream 流式对话回复（stream: true），每收到一段增量文本调用一次onDelta，返回完整回复
+// onDelta返回错误或者ctx取消时中断读取，接口没有返回用量时按已收到的内容估算，
+// 回复因为max_tokens被截断时自动请求接着写，增量文本继续交给onDelta
+func ChatCompletionsStream(ctx context.Context, settings ModelSettings, messages []Message, onDelta func(delta string) error) (string, Usage, error) {
+	requestBody := newChatCompletionRequestBody(settings, messages)
+	requestBody.Stream = true
+	var reply strings.Builder
+	var usage Usage
+	for round := 0; ; round++ {
+		body := requestBody
+		if round > 0 {
+			body.Messages = continueMessages(messages, reply.String())
+		}
+		part, finishReason, partUsage, err := streamCompletions(ctx, &body, onDelta)
+		// 接着写使用实际回答的模型，不回到超时或者过载的模型
+		requestBody.Model, requestBody.endpoint = body.Model, body.endpoint
+		reply.WriteString(part)
+		usage.Add(partUsage)
+		if err != nil {
+			// 接着写失败时返回已有的回复
+			if round > 0 && ctx.Err() == nil {
+				logger.Warning(fmt.Sprintf("gpt stream continue error: %v", err))
+				return reply.String(), usage, nil
+			}
+			return reply.String(), usage, err
+		}
+		if !shouldContinue(finishReason, round) {
+			return reply.String(), usage, nil
+		}
+		log.Printf("gpt stream reply truncated, continue(%d)\n", round+1)
+	}
+}
+
+// streamCompletions 发送一次流式请求，返回收到的回复、结束原因以及用量，换了备用模型时把requestBody改成实际回答的模型
+func streamCompletions(ctx context.Context, requestBody *ChatCompletionRequestBody, onDelta func(delta string) error) (string, string, Usage, error) {
+	// 1.建立连接，收到增量文本之前的错误可以重试，仍然失败时换备用模型
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var response *http.Response
+	err := withFallback(ctx, *requestBody, false, func(ctx context.Context, body ChatCompletionRequestBody) error {
+		return withRetry(ctx, "gpt stream "+body.Model, func(attempt int) error {
+			request, err := newChatCompletionsRequest(body, attempt)
+			if err != nil {
+				return err
+			}
+			response, err = doRequest(ctx, streamClient, request)
+			if err == nil {
+				requestBody.Model, requestBody.endpoint = body.Model, body.endpoint
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return "", "", Usage{}, err
+	}
+	defer response.Body.Close()
+
+	// 2.逐行读取SSE事件，超过request_timeout没有收到数据时中断，服务端发完响应头后卡住不会一直占着请求
+	body := io.Reader(response.Body)
+	timeout := time.Second * config.LoadConfig().RequestTimeout
+	var idle *idleReader
+	if timeout > 0 {
+		idle = newIdleReader(response.Body, timeout, cancel)
+		defer idle.Stop()
+		body = idle
+	}
+	reply, finishReason, usage, err := readStream(body, *requestBody, onDelta)
+	if err != nil {
+		if idle != nil && idle.Expired() {
+			err = fmt.Errorf("read stream error: no data for %v: %w", timeout, context.DeadlineExceeded)
+		}
+		return reply, finishReason, usage, err
+	}
+	log.Printf("gpt stream reply: %s\n", reply)
+	return reply, finishReason, usage, nil
+}
+
+// readStream 逐行读取SSE事件，data: [DONE] 表示结束，返回收到的回复、结束原因以及用量
+func readStream(body io.Reader, requestBody ChatCompletionRequestBody, onDelta func(delta string) error) (string, string, Usage, error) {
+	var reply strings.Builder
+	var finishReason string
+	var streamUsage *Usage
+	// 中断时也返回已收到的内容和用量
+	finish := func(err error) (string, string, Usage, error) {
+		usage := estimateUsage(requestBody.Model, requestBody.Messages, reply.String())
+		if streamUsage != nil {
+			usage = *streamUsage
+		}
+		return reply.String(), finishReason, usage, err
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		chunk := &ChatCompletionStreamResponseBody{}
+		if err := json.Unmarshal([]byte(data), chunk); err != nil {
+			return finish(fmt.Errorf("json.Unmarshal stream chunk error: %v", err))
+		}
+		if chunk.Usage != nil {
+			streamUsage = chunk.Usage
+			streamUsage.Model = chunk.Model
+			if streamUsage.Model == "" {
+				streamUsage.Model = requestBody.Model
+			}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			continue
+		}
+		reply.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return finish(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return finish(fmt.Errorf("read stream error: %w", err))
+	}
+	return finish(nil)
+}
+
+// idleReader 每次读到数据时重新计时，超过timeout没有数据时调用cancel中断读取
+type idleReader struct {
+	reader  io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleReader(reader io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	r := &idleReader{reader: reader, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.expired.Store(true)
+		cancel()
+	})
+	return r
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// Stop 读取结束时停止计时
+func (r *idleReader) Stop() {
+	r.timer.Stop()
+}
+
+// Expired 是否因为超时中断了读取
+func (r *idleReader) Expired() bool {
+	return r.expired.Load()
+}
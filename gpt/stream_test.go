@@ -0,0 +1,162 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+func TestReadStream(t *testing.T) {
+	requestBody := ChatCompletionRequestBody{Model: testModel, Messages: []Message{{Role: RoleUser, Content: "你好"}}}
+	tests := []struct {
+		name         string
+		stream       string
+		reply        string
+		finishReason string
+		usage        Usage
+		err          bool
+	}{
+		{
+			name: "deltas until done",
+			stream: ": keep-alive\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"你\"}}]}\n\n" +
+				"data:{\"choices\":[{\"delta\":{\"content\":\"好\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"ignored\"}}]}\n\n",
+			reply:        "你好",
+			finishReason: "stop",
+		},
+		{
+			name: "usage in last event",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"length\"}]}\n" +
+				"data: {\"model\":\"gpt-4\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\n" +
+				"data: [DONE]\n",
+			reply:        "hi",
+			finishReason: "length",
+			usage:        Usage{Model: "gpt-4", PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+		},
+		{
+			name:   "stream ends without done",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n",
+			reply:  "hi",
+		},
+		{
+			name:   "malformed chunk",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\ndata: {broken\n",
+			reply:  "hi",
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		var deltas []string
+		reply, finishReason, usage, err := readStream(strings.NewReader(tt.stream), requestBody, func(delta string) error {
+			deltas = append(deltas, delta)
+			return nil
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		}
+		if reply != tt.reply || strings.Join(deltas, "") != tt.reply {
+			t.Errorf("%s: reply = %q, deltas = %q, want %q", tt.name, reply, deltas, tt.reply)
+		}
+		if finishReason != tt.finishReason {
+			t.Errorf("%s: finishReason = %q, want %q", tt.name, finishReason, tt.finishReason)
+		}
+		// 接口没有返回用量时按收到的内容估算
+		want := tt.usage
+		if want.TotalTokens == 0 {
+			want = estimateUsage(testModel, requestBody.Messages, tt.reply)
+		}
+		if usage != want {
+			t.Errorf("%s: usage = %+v, want %+v", tt.name, usage, want)
+		}
+	}
+}
+
+func TestReadStreamStopsOnDeltaError(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"b\"}}]}\n"
+	stop := errors.New("stop")
+	calls := 0
+	reply, _, _, err := readStream(strings.NewReader(stream), ChatCompletionRequestBody{Model: testModel}, func(delta string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 || reply != "a" {
+		t.Fatalf("readStream = %q, %v after %d calls, want a, stop after 1 call", reply, err, calls)
+	}
+}
+
+func TestIdleReader(t *testing.T) {
+	// 一直没有数据时超时中断
+	reader, writer := io.Pipe()
+	idle := newIdleReader(reader, 20*time.Millisecond, func() {
+		writer.CloseWithError(context.Canceled)
+	})
+	defer idle.Stop()
+	if _, err := io.ReadAll(idle); !errors.Is(err, context.Canceled) || !idle.Expired() {
+		t.Fatalf("stalled read = %v, expired %v, want canceled after the idle timeout", err, idle.Expired())
+	}
+
+	// 持续收到数据时不超时，总时长可以超过timeout
+	reader, writer = io.Pipe()
+	idle = newIdleReader(reader, 50*time.Millisecond, func() {
+		writer.CloseWithError(context.Canceled)
+	})
+	defer idle.Stop()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			writer.Write([]byte("data: {}\n"))
+		}
+		writer.Close()
+	}()
+	data, err := io.ReadAll(idle)
+	if err != nil || idle.Expired() || strings.Count(string(data), "\n") != 5 {
+		t.Fatalf("steady read = %q, %v, expired %v, want all lines", data, err, idle.Expired())
+	}
+}
+
+// useTestServer 把接口地址指向本地的测试服务，测试结束后恢复配置
+func useTestServer(t *testing.T, handler http.HandlerFunc) *config.Configuration {
+	server := httptest.NewServer(handler)
+	cfg := config.LoadConfig()
+	saved := *cfg
+	cfg.Provider, cfg.BaseURL, cfg.ApiKey, cfg.ApiKeys = ProviderOpenAI, server.URL, "test-key", nil
+	cfg.KeyProbeInterval, cfg.RequestTimeout, cfg.MaxContinuations = 0, 60, 0
+	cfg.Fallbacks = nil
+	t.Cleanup(func() {
+		server.Close()
+		*cfg = saved
+	})
+	return cfg
+}
+
+func TestChatCompletionsStreamIdleTimeout(t *testing.T) {
+	cfg := useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// 发出响应头和一段内容后不再发送数据
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	cfg.RequestTimeout = 1
+
+	start := time.Now()
+	reply, _, err := ChatCompletionsStream(context.Background(), NewModelSettings(testModel), []Message{{Role: RoleUser, Content: "hi"}}, func(string) error { return nil })
+	if ErrorKindOf(err) != ErrorTimeout || reply != "hi" {
+		t.Fatalf("stalled stream = %q, %v, want hi and a timeout", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("stalled stream took %v to time out", elapsed)
+	}
+}
@@ -0,0 +1,132 @@
+package gpt
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+const (
+	// defaultEncoding 未知模型使用的编码
+	defaultEncoding = "cl100k_base"
+	// defaultContextWindow 未知模型的上下文窗口
+	defaultContextWindow = 4096
+	// tokensPerMessage 每条消息角色等格式的额外开销
+	tokensPerMessage = 3
+	// tokensReplyPrimed 每次回复的固定开销
+	tokensReplyPrimed = 3
+)
+
+// contextWindows 模型上下文窗口，按前缀匹配，越具体的前缀越靠前
+var contextWindows = []struct {
+	prefix string
+	size   int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-0125", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo-16k", 16385},
+	{"gpt-3.5-turbo-1106", 16385},
+	{"gpt-3.5-turbo-0125", 16385},
+	{"gpt-3.5-turbo", 4096},
+	{"gpt-35-turbo-16k", 16385},
+	{"gpt-35-turbo", 4096},
+}
+
+var (
+	encodings     = map[string]*tiktoken.Tiktoken{}
+	encodingsLock sync.Mutex
+)
+
+func init() {
+	// 使用内置的BPE词表，不在运行时下载
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// encodingForModel 获取模型对应的编码（cl100k_base、p50k_base等），未知模型使用cl100k_base
+func encodingForModel(model string) *tiktoken.Tiktoken {
+	encodingsLock.Lock()
+	defer encodingsLock.Unlock()
+	if encoding, ok := encodings[model]; ok {
+		return encoding
+	}
+	encoding, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		encoding, err = tiktoken.GetEncoding(defaultEncoding)
+		if err != nil {
+			logger.Danger("tiktoken get encoding error:", err)
+			return nil
+		}
+	}
+	encodings[model] = encoding
+	return encoding
+}
+
+// CountTokens 计算文本在指定模型下的token数
+func CountTokens(model, text string) int {
+	encoding := encodingForModel(model)
+	if encoding == nil {
+		// 词表加载失败时按字符数估算，中文一个字符大约一个token
+		return len([]rune(text))
+	}
+	return len(encoding.EncodeOrdinary(text))
+}
+
+// CountMessageTokens 计算单条对话消息的token数，包含角色等格式开销
+func CountMessageTokens(model string, message Message) int {
+	tokens := tokensPerMessage + CountTokens(model, message.Role) + CountTokens(model, message.Content)
+	for _, part := range message.Parts {
+		tokens += part.tokens(model)
+	}
+	return tokens
+}
+
+// CountMessagesTokens 计算一组对话消息作为请求时的token数
+func CountMessagesTokens(model string, messages []Message) int {
+	tokens := tokensReplyPrimed
+	for _, message := range messages {
+		tokens += CountMessageTokens(model, message)
+	}
+	return tokens
+}
+
+// TruncateTokens 按token截断文本，只保留前max个token，不会截断半个汉字
+func TruncateTokens(model, text string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	encoding := encodingForModel(model)
+	if encoding == nil {
+		runes := []rune(text)
+		if len(runes) > max {
+			return string(runes[:max])
+		}
+		return text
+	}
+	tokens := encoding.EncodeOrdinary(text)
+	if len(tokens) <= max {
+		return text
+	}
+	// 一个汉字可能被拆成多个token，去掉末尾解码不完整的字符
+	return strings.TrimRight(encoding.Decode(tokens[:max]), "�")
+}
+
+// ContextWindow 模型上下文窗口大小，优先使用配置
+func ContextWindow(model string) int {
+	if size, ok := config.LoadConfig().ContextWindows[model]; ok && size > 0 {
+		return size
+	}
+	for _, item := range contextWindows {
+		if strings.HasPrefix(model, item.prefix) {
+			return item.size
+		}
+	}
+	return defaultContextWindow
+}
@@ -0,0 +1,84 @@
+package gpt
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const testModel = "gpt-3.5-turbo"
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 1},
+		{"hello world", 2},
+		{strings.Repeat("hello ", 10), 11},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(testModel, tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountMessageTokens(t *testing.T) {
+	message := Message{Role: RoleUser, Content: "hello world"}
+	want := tokensPerMessage + CountTokens(testModel, RoleUser) + 2
+	if got := CountMessageTokens(testModel, message); got != want {
+		t.Errorf("CountMessageTokens = %d, want %d", got, want)
+	}
+	messages := []Message{message, message}
+	if got := CountMessagesTokens(testModel, messages); got != tokensReplyPrimed+2*want {
+		t.Errorf("CountMessagesTokens = %d, want %d", got, tokensReplyPrimed+2*want)
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"hello world", 0, ""},
+		{"hello world", 1, "hello"},
+		{"hello world", 2, "hello world"},
+		{"hello world", 10, "hello world"},
+	}
+	for _, tt := range tests {
+		if got := TruncateTokens(testModel, tt.text, tt.max); got != tt.want {
+			t.Errorf("TruncateTokens(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+		}
+	}
+
+	// 汉字被拆成多个token时不留下半个字
+	text := strings.Repeat("机器人回复", 20)
+	for max := 1; max < 20; max++ {
+		got := TruncateTokens(testModel, text, max)
+		if !utf8.ValidString(got) || strings.ContainsRune(got, utf8.RuneError) || !strings.HasPrefix(text, got) {
+			t.Errorf("TruncateTokens(%d) = %q, not a clean prefix", max, got)
+		}
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4o-mini", 128000},
+		{"gpt-4-0613", 8192},
+		{"gpt-4-32k-0613", 32768},
+		{"gpt-3.5-turbo-16k", 16385},
+		{"gpt-3.5-turbo", 4096},
+		{"my-local-model", defaultContextWindow},
+	}
+	for _, tt := range tests {
+		if got := ContextWindow(tt.model); got != tt.want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
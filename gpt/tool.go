@@ -0,0 +1,76 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Tool 通过chat的tools字段告知模型可以调用的工具
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition 函数工具定义，Parameters为参数的JSON schema
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall 模型要求调用的工具
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall 函数名以及JSON格式的参数
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolExecutor 执行工具调用，返回给模型的结果
+type ToolExecutor func(ctx context.Context, call ToolCall) string
+
+// ChatCompletionsWithTools 带工具的对话：把tools告知模型，模型要求调用工具时执行并把结果回传，
+// 直到模型给出最终回答，最多进行maxRounds轮工具调用，返回的用量是各轮之和
+func ChatCompletionsWithTools(ctx context.Context, settings ModelSettings, messages []Message, tools []Tool, execute ToolExecutor, maxRounds int) (string, Usage, error) {
+	messages = append([]Message(nil), messages...)
+	var usage Usage
+	for round := 0; ; round++ {
+		requestBody := newChatCompletionRequestBody(settings, messages)
+		// 达到轮数上限后不再提供工具，让模型直接回答
+		if round < maxRounds {
+			requestBody.Tools = tools
+		}
+		gptResponseBody, err := chatCompletions(ctx, &requestBody)
+		if err != nil {
+			return "", usage, err
+		}
+		usage.Add(responseUsage(requestBody, gptResponseBody))
+		if len(gptResponseBody.Choices) == 0 {
+			return "", usage, errors.New("gpt response without choices")
+		}
+		message := gptResponseBody.Choices[0].Message
+		if len(message.ToolCalls) == 0 {
+			reply := continueCompletions(ctx, requestBody, message.Content, gptResponseBody.Choices[0].FinishReason, &usage)
+			return reply, usage, nil
+		}
+		if round >= maxRounds {
+			return "", usage, fmt.Errorf("tool calls exceed %d rounds", maxRounds)
+		}
+
+		// 执行模型要求的工具，结果按调用ID回传
+		messages = append(messages, message)
+		for _, call := range message.ToolCalls {
+			result := execute(ctx, call)
+			log.Printf("gpt tool call %s(%s): %s\n", call.Function.Name, call.Function.Arguments, result)
+			messages = append(messages, Message{Role: RoleTool, Content: result, ToolCallID: call.ID})
+		}
+	}
+}
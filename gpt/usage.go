@@ -0,0 +1,84 @@
+package gpt
+
+import (
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// Usage 一次请求的token用量
+type Usage struct {
+	// 实际回答的模型
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+// Add 累加用量，如工具调用的多轮请求
+func (u *Usage) Add(other Usage) {
+	if other.Model != "" {
+		u.Model = other.Model
+	}
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// modelPrices 模型每1K token的美元价格，按前缀匹配，越具体的前缀越靠前
+var modelPrices = []struct {
+	prefix string
+	price  config.ModelPrice
+}{
+	{"gpt-4o-mini", config.ModelPrice{Prompt: 0.00015, Completion: 0.0006}},
+	{"gpt-4o", config.ModelPrice{Prompt: 0.005, Completion: 0.015}},
+	{"gpt-4-turbo", config.ModelPrice{Prompt: 0.01, Completion: 0.03}},
+	{"gpt-4-1106", config.ModelPrice{Prompt: 0.01, Completion: 0.03}},
+	{"gpt-4-0125", config.ModelPrice{Prompt: 0.01, Completion: 0.03}},
+	{"gpt-4-32k", config.ModelPrice{Prompt: 0.06, Completion: 0.12}},
+	{"gpt-4", config.ModelPrice{Prompt: 0.03, Completion: 0.06}},
+	{"gpt-3.5-turbo-16k", config.ModelPrice{Prompt: 0.003, Completion: 0.004}},
+	{"gpt-3.5-turbo", config.ModelPrice{Prompt: 0.0005, Completion: 0.0015}},
+	{"gpt-35-turbo", config.ModelPrice{Prompt: 0.0005, Completion: 0.0015}},
+}
+
+// EstimateCost 按模型价格估算费用，单位美元，优先使用配置的价格，未知模型按0计算
+func EstimateCost(usage Usage) float64 {
+	price, ok := config.LoadConfig().ModelPrices[usage.Model]
+	if !ok {
+		for _, item := range modelPrices {
+			if strings.HasPrefix(usage.Model, item.prefix) {
+				price, ok = item.price, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1000
+}
+
+// estimateUsage 接口没有返回用量时（如流式回复）按tokenizer估算
+func estimateUsage(model string, messages []Message, reply string) Usage {
+	usage := Usage{
+		Model:            model,
+		PromptTokens:     CountMessagesTokens(model, messages),
+		CompletionTokens: CountTokens(model, reply),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// responseUsage 取响应中的用量，补上回答的模型
+func responseUsage(requestBody ChatCompletionRequestBody, responseBody *ChatCompletionResponseBody) Usage {
+	usage := responseBody.Usage
+	usage.Model = responseBody.Model
+	if usage.Model == "" {
+		usage.Model = requestBody.Model
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage
+}
@@ -0,0 +1,79 @@
+package gpt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// 消息内容类型
+const (
+	ContentTypeText     = "text"
+	ContentTypeImageURL = "image_url"
+)
+
+// 图片按detail估算的token数，low固定85，其他按一张1024x1024的图估算
+const (
+	imageTokensLow  = 85
+	imageTokensHigh = 765
+)
+
+// ContentPart 多模态消息的一段内容
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 图片地址，可以是data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// NewImagePart 用图片数据创建图片内容，detail可选low、high、auto
+func NewImagePart(data []byte, detail string) ContentPart {
+	url := "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return ContentPart{Type: ContentTypeImageURL, ImageURL: &ImageURL{URL: url, Detail: detail}}
+}
+
+// tokens 估算内容的token数
+func (p ContentPart) tokens(model string) int {
+	switch {
+	case p.ImageURL == nil:
+		return CountTokens(model, p.Text)
+	case p.ImageURL.Detail == "low":
+		return imageTokensLow
+	default:
+		return imageTokensHigh
+	}
+}
+
+// MarshalJSON 有Parts时content按数组发送，文本放在最前面
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+	var parts []ContentPart
+	if m.Content != "" {
+		parts = append(parts, ContentPart{Type: ContentTypeText, Text: m.Content})
+	}
+	parts = append(parts, m.Parts...)
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message(m), parts})
+}
+
+// hasImage 消息中是否带图片
+func hasImage(messages []Message) bool {
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if part.Type == ContentTypeImageURL {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// errorReplyText 根据GPT错误类型生成给用户的提示
+func errorReplyText(err error) string {
+	switch gpt.ErrorKindOf(err) {
+	case gpt.ErrorTimeout:
+		return deadlineExceededText
+	case gpt.ErrorRateLimit:
+		return "提问的人太多了[捂脸]GPT接口限流中，请稍后再问[旺柴]"
+	case gpt.ErrorQuotaExhausted:
+		return "GPT额度已经用完了[裂开]请联系管理员充值"
+	case gpt.ErrorInvalidKey:
+		return "GPT的api key无效或已过期[裂开]请联系管理员更换"
+	case gpt.ErrorContextTooLong:
+		return "上下文太长了，请发送清空会话口令后重新提问"
+	case gpt.ErrorServer:
+		return "GPT服务器开小差了[裂开]请稍后重新发送问题[旺柴]"
+	default:
+		return "请求GPT出错了：" + err.Error()
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// noExchangeText 会话中没有问答时的提示
+const noExchangeText = "当前会话中还没有可以操作的问答"
+
+// handleUndoCommand 处理撤销指令，从会话中删掉上一轮问答，不是指令时ok为false
+func handleUndoCommand(text string, userService service.UserServiceInterface) (reply string, ok bool) {
+	prefix := config.LoadConfig().UndoCommand
+	if prefix == "" || strings.TrimSpace(text) != prefix {
+		return "", false
+	}
+	undone := userService.UndoExchange()
+	if len(undone) == 0 {
+		return noExchangeText, true
+	}
+	return "已撤销上一轮问答：" + abbreviate(undone[0].Content, 20), true
+}
+
+// isRetryCommand 是否为重新回答指令
+func isRetryCommand(text string) bool {
+	prefix := config.LoadConfig().RetryCommand
+	return prefix != "" && strings.TrimSpace(text) == prefix
+}
+
+// retryRequest 重新回答时的提问：上一轮的原始文本，含单次指定模型的指令
+func retryRequest(retried []service.Turn) string {
+	if retried[0].Request != "" {
+		return retried[0].Request
+	}
+	return retried[0].Content
+}
+
+// retrySettings 重新回答时沿用上一轮使用的模型，模型已经从配置中去掉时使用本次选择的模型
+func retrySettings(settings gpt.ModelSettings, retried []service.Turn) gpt.ModelSettings {
+	if len(retried) == 0 || retried[0].Model == "" || !gpt.ModelAllowed(retried[0].Model) {
+		return settings
+	}
+	return gpt.NewModelSettings(retried[0].Model)
+}
+
+// saveExchange 记下本轮问答和提问带的图片，重新回答时替换被重新回答的那一轮，请求失败时不调用，上一轮保持不变
+func saveExchange(userService service.UserServiceInterface, retried []service.Turn, question, answer service.Turn, images [][]byte) {
+	if len(retried) > 0 {
+		userService.ReplaceExchange(retried, question, answer)
+	} else {
+		userService.AppendHistory(question, answer)
+	}
+	userService.SetExchangeImages(question, images)
+}
+
+// abbreviate 截取前n个字符，超出的用省略号代替
+func abbreviate(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n]) + "…"
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+func TestRetryRequest(t *testing.T) {
+	tests := []struct {
+		question service.Turn
+		want     string
+	}{
+		{service.Turn{Content: "你好"}, "你好"},
+		{service.Turn{Content: "你好"}.WithRequest("/gpt-4 你好", "gpt-4"), "/gpt-4 你好"},
+	}
+	for _, tt := range tests {
+		if got := retryRequest([]service.Turn{tt.question, {}}); got != tt.want {
+			t.Errorf("retryRequest(%+v) = %q, want %q", tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestRetrySettings(t *testing.T) {
+	settings := gpt.NewModelSettings("gpt-3.5-turbo")
+	tests := []struct {
+		name    string
+		retried []service.Turn
+	}{
+		{"not a retry", nil},
+		{"no model recorded", []service.Turn{{}, {}}},
+		{"model no longer configured", []service.Turn{service.Turn{}.WithRequest("", "removed-model"), {}}},
+	}
+	for _, tt := range tests {
+		if got := retrySettings(settings, tt.retried); got.Model != settings.Model {
+			t.Errorf("%s: retrySettings model = %q, want %q", tt.name, got.Model, settings.Model)
+		}
+	}
+}
+
+func TestAbbreviate(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"你好", 2, "你好"},
+		{"你好世界", 2, "你好…"},
+		{"", 2, ""},
+	}
+	for _, tt := range tests {
+		if got := abbreviate(tt.text, tt.n); got != tt.want {
+			t.Errorf("abbreviate(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
@@ -1,26 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"github.com/eatmoreapple/openwechat"
-	"github.com/patrickmn/go-cache"
 	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/tools"
 	"github.com/skip2/go-qrcode"
 	"log"
+	"math/rand"
 	"runtime"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const deadlineExceededText = "请求GPT服务器超时[裂开]得不到回复，请重新发送问题[旺柴]"
 
-var c = cache.New(config.LoadConfig().SessionTimeout, time.Minute*5)
-
 // MessageHandlerInterface 消息处理接口
 type MessageHandlerInterface interface {
-	handle() error
-	ReplyText() error
+	handle(ctx context.Context) error
+	ReplyText(ctx context.Context) error
 }
 
 // QrCodeCallBack 登录扫码回调，
@@ -35,18 +37,19 @@ func QrCodeCallBack(uuid string) {
 	}
 }
 
-func NewHandler() (msgFunc func(msg *openwechat.Message), err error) {
+// NewHandler 创建消息处理函数，ctx结束时（如退出登录）取消所有进行中的请求
+func NewHandler(ctx context.Context) (msgFunc func(msg *openwechat.Message), err error) {
 	dispatcher := openwechat.NewMessageMatchDispatcher()
+	// 异步处理消息，等待GPT回复期间也能收到取消口令
+	dispatcher.SetAsync(true)
 
-	// 清空会话
-	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return strings.Contains(message.Content, config.LoadConfig().SessionClearToken)
-	}, TokenMessageContextHandler())
+	// 清空会话、取消回复口令
+	dispatcher.RegisterHandler(isTokenMessage, TokenMessageContextHandler(ctx))
 
 	// 处理群消息
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return message.IsSendByGroup()
-	}, GroupMessageContextHandler())
+		return message.IsSendByGroup() && !isTokenMessage(message)
+	}, GroupMessageContextHandler(ctx))
 
 	// 好友申请
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
@@ -65,7 +68,76 @@ func NewHandler() (msgFunc func(msg *openwechat.Message), err error) {
 	// 私聊
 	// 获取用户消息处理器
 	dispatcher.RegisterHandler(func(message *openwechat.Message) bool {
-		return !(strings.Contains(message.Content, config.LoadConfig().SessionClearToken) || message.IsSendByGroup() || message.IsFriendAdd())
-	}, UserMessageContextHandler())
+		return !(isTokenMessage(message) || message.IsSendByGroup() || message.IsFriendAdd())
+	}, UserMessageContextHandler(ctx))
 	return openwechat.DispatchMessage(dispatcher), nil
 }
+
+// isTokenMessage 是否为口令消息：包含清空会话口令，或者去掉@之后正好是取消口令
+func isTokenMessage(message *openwechat.Message) bool {
+	cfg := config.LoadConfig()
+	return strings.Contains(message.Content, cfg.SessionClearToken) || isCancelToken(message.Content)
+}
+
+// isCancelToken 去掉@机器人的部分之后是否正好是取消口令
+func isCancelToken(content string) bool {
+	token := config.LoadConfig().CancelToken
+	if token == "" {
+		return false
+	}
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "@") {
+		// 群里 @机器人 取消，昵称和口令之间是特殊空格，占多个字节
+		if i := strings.IndexAny(content, " \u2005"); i >= 0 {
+			_, size := utf8.DecodeRuneInString(content[i:])
+			content = strings.TrimSpace(content[i+size:])
+		}
+	}
+	return content == token
+}
+
+// sleepRandom 随机等待1-5秒再回复，降低风控风险，ctx结束时提前返回
+func sleepRandom(ctx context.Context) error {
+	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
+	timer := time.NewTimer(time.Duration(maxInt+1) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// requestTimeout 单次请求的截止时间，流式回复边生成边发送，不设截止时间，由流式读取的空闲超时兜底
+func requestTimeout(cfg *config.Configuration) time.Duration {
+	if useStream(cfg) {
+		return 0
+	}
+	return time.Second * cfg.RequestTimeout
+}
+
+// withCallTimeout 非流式调用（对话、画图、语音）的截止时间，流式回复时整个请求没有截止时间，这些调用仍按request_timeout限制
+func withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := time.Second * config.LoadConfig().RequestTimeout
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// useStream 是否流式回复，启用工具时需要拿到完整的工具调用，不使用流式
+func useStream(cfg *config.Configuration) bool {
+	return cfg.Stream && tools.Default().Len() == 0
+}
+
+// chatCompletions 请求GPT回复以及本次用量，启用了工具时由模型按需调用工具
+func chatCompletions(ctx context.Context, settings gpt.ModelSettings, messages []gpt.Message) (string, gpt.Usage, error) {
+	ctx, cancel := withCallTimeout(ctx)
+	defer cancel()
+	registry := tools.Default()
+	if registry.Len() == 0 {
+		return gpt.ChatCompletions(ctx, settings, messages)
+	}
+	return gpt.ChatCompletionsWithTools(ctx, settings, messages, registry.Definitions(), registry.Execute, config.LoadConfig().MaxToolRounds)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestIsCancelToken(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"取消", true},
+		{"  取消\n", true},
+		{"@机器人 取消", true},
+		{"@机器人 取消", true},
+		{"@机器人 取消 ", true},
+		{"@机器人 取消订单", false},
+		{"@机器人 取消订单", false},
+		{"@机器人", false},
+		{"取消订单", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isCancelToken(tt.content); got != tt.want {
+			t.Errorf("isCancelToken(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
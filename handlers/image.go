@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// maxImageCount 一次最多生成的图片数
+const maxImageCount = 4
+
+// imageSizes 支持的图片尺寸
+var imageSizes = map[string]bool{
+	"256x256":   true,
+	"512x512":   true,
+	"1024x1024": true,
+	"1792x1024": true,
+	"1024x1792": true,
+}
+
+// imageCommand 画图指令
+type imageCommand struct {
+	prompt string
+	n      int
+	size   string
+}
+
+// parseImageCommand 解析画图指令：/img [-s 尺寸] [-n 张数] 描述，不是画图指令时返回nil
+func parseImageCommand(text string) (*imageCommand, error) {
+	prefix := config.LoadConfig().ImageCommand
+	text = strings.TrimSpace(text)
+	if prefix == "" || !strings.HasPrefix(text, prefix) {
+		return nil, nil
+	}
+	rest := text[len(prefix):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\n' {
+		// 如 /imgxxx 不是画图指令
+		return nil, nil
+	}
+
+	cmd := &imageCommand{n: 1, size: config.LoadConfig().ImageSize}
+	usage := fmt.Sprintf("用法：%s [-s 尺寸] [-n 张数] 描述", prefix)
+	fields := strings.Fields(rest)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+		if fields[0] != "-s" && fields[0] != "-n" {
+			return nil, fmt.Errorf("不支持的参数%s，%s", fields[0], usage)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("参数%s缺少值，%s", fields[0], usage)
+		}
+		switch fields[0] {
+		case "-s":
+			if !imageSizes[fields[1]] {
+				return nil, fmt.Errorf("不支持的图片尺寸%s，可选256x256、512x512、1024x1024、1792x1024、1024x1792", fields[1])
+			}
+			cmd.size = fields[1]
+		case "-n":
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 1 || n > maxImageCount {
+				return nil, fmt.Errorf("图片张数需要是1到%d之间的数字", maxImageCount)
+			}
+			cmd.n = n
+		}
+		fields = fields[2:]
+	}
+	cmd.prompt = strings.Join(fields, " ")
+	if cmd.prompt == "" {
+		return nil, fmt.Errorf("请告诉我要画什么，%s", usage)
+	}
+	return cmd, nil
+}
+
+// replyImages 根据画图指令生成图片并逐张发送
+func replyImages(ctx context.Context, msg *openwechat.Message, cmd *imageCommand) error {
+	ctx, cancel := withCallTimeout(ctx)
+	defer cancel()
+	images, err := gpt.GenerateImages(ctx, cmd.prompt, cmd.n, cmd.size)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if err := replyImage(msg, image); err != nil {
+			return fmt.Errorf("reply image error: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import "testing"
+
+func TestParseImageCommand(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    *imageCommand
+		wantErr bool
+	}{
+		{text: "你好"},
+		{text: "/imgxxx 猫"},
+		{text: "/img 一只猫", want: &imageCommand{prompt: "一只猫", n: 1, size: "1024x1024"}},
+		{text: "/img -s 512x512 -n 2 一只 猫", want: &imageCommand{prompt: "一只 猫", n: 2, size: "512x512"}},
+		{text: "/img 猫 -n", want: &imageCommand{prompt: "猫 -n", n: 1, size: "1024x1024"}},
+		{text: "/img", wantErr: true},
+		{text: "/img -n", wantErr: true},
+		{text: "/img -s", wantErr: true},
+		{text: "/img -x", wantErr: true},
+		{text: "/img -x 猫", wantErr: true},
+		{text: "/img -n 2", wantErr: true},
+		{text: "/img -n 9 猫", wantErr: true},
+		{text: "/img -n 两 猫", wantErr: true},
+		{text: "/img -s 100x100 猫", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseImageCommand(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseImageCommand(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("parseImageCommand(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// inflightRequests 进行中的GPT请求，按用户记录取消函数，用于取消口令
+type inflightRequests struct {
+	lock    sync.Mutex
+	entries map[string]*inflightRequest
+}
+
+type inflightRequest struct {
+	cancel context.CancelFunc
+}
+
+var requests = &inflightRequests{entries: map[string]*inflightRequest{}}
+
+// start 开始一次请求，timeout大于0时设置截止时间，返回的done在请求结束时调用
+// 同一用户新的请求会覆盖旧的记录，取消时只取消最新的请求
+func (r *inflightRequests) start(parent context.Context, key string, timeout time.Duration) (context.Context, func()) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	entry := &inflightRequest{cancel: cancel}
+
+	r.lock.Lock()
+	r.entries[key] = entry
+	r.lock.Unlock()
+
+	return ctx, func() {
+		r.lock.Lock()
+		if r.entries[key] == entry {
+			delete(r.entries, key)
+		}
+		r.lock.Unlock()
+		cancel()
+	}
+}
+
+// cancel 取消用户进行中的请求，没有进行中的请求时返回false
+func (r *inflightRequests) cancel(key string) bool {
+	r.lock.Lock()
+	entry, ok := r.entries[key]
+	delete(r.entries, key)
+	r.lock.Unlock()
+	if ok {
+		entry.cancel()
+	}
+	return ok
+}
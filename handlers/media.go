@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/eatmoreapple/openwechat"
+)
+
+// replyImage 从内存发送图片，openwechat只接受*os.File上传，写入临时文件，发送完立即删除
+func replyImage(msg *openwechat.Message, data []byte) error {
+	file, err := writeTempFile(data, "wechatbot-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	_, err = msg.ReplyImage(file)
+	return err
+}
+
+// replyFile 从内存发送文件，pattern决定文件后缀
+func replyFile(msg *openwechat.Message, data []byte, pattern string) error {
+	file, err := writeTempFile(data, pattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	_, err = msg.ReplyFile(file)
+	return err
+}
+
+// writeTempFile 把内存中的数据写入临时文件，pattern决定文件后缀，微信按后缀判断文件类型
+func writeTempFile(data []byte, pattern string) (*os.File, error) {
+	file, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("create temp file error: %v", err)
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("write temp file error: %v", err)
+	}
+	return file, nil
+}
+
+// readMedia 读取微信媒体消息的下载响应
+func readMedia(response *http.Response, err error) ([]byte, error) {
+	if err != nil {
+		return nil, fmt.Errorf("download media error: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download media error: status %d", response.StatusCode)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read media error: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("download media error: empty body")
+	}
+	return data, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// modelQuestionEmptyText 单次指定模型的指令后面没有问题时的提示
+const modelQuestionEmptyText = "请在指令后面输入问题"
+
+// handleModelCommand 处理切换模型指令：/model 查看当前和可用的模型，/model 模型名 切换，/model default 恢复默认，
+// 不是指令时ok为false
+func handleModelCommand(text string, userService service.UserServiceInterface) (reply string, ok bool) {
+	prefix := config.LoadConfig().ModelCommand
+	fields := strings.Fields(text)
+	if prefix == "" || len(fields) == 0 || fields[0] != prefix {
+		return "", false
+	}
+	if len(fields) == 1 {
+		return fmt.Sprintf("当前模型：%s\n可用模型：%s\n切换：%s 模型名，恢复默认：%s default",
+			currentModel(userService), strings.Join(modelNames(), "、"), prefix, prefix), true
+	}
+
+	model := fields[1]
+	if model == "default" {
+		userService.SetUserModel("")
+		return "已恢复默认模型" + config.LoadConfig().Model, true
+	}
+	if !gpt.ModelAllowed(model) {
+		return fmt.Sprintf("不支持模型%s，可用模型：%s", model, strings.Join(modelNames(), "、")), true
+	}
+	userService.SetUserModel(model)
+	return "之后的提问将使用" + model, true
+}
+
+// selectModel 选择本次提问的模型：/指令 问题 单次指定，其次是用户切换的模型，最后是默认模型，返回去掉指令的问题
+func selectModel(text string, userService service.UserServiceInterface) (gpt.ModelSettings, string) {
+	if model, question, ok := parseModelPrefix(text); ok {
+		return gpt.NewModelSettings(model), question
+	}
+	return gpt.NewModelSettings(currentModel(userService)), text
+}
+
+// currentModel 用户当前使用的模型，切换过的模型已经从配置中去掉时使用默认模型
+func currentModel(userService service.UserServiceInterface) string {
+	if model := userService.GetUserModel(); model != "" && gpt.ModelAllowed(model) {
+		return model
+	}
+	return config.LoadConfig().Model
+}
+
+// parseModelPrefix 解析单次指定模型的指令，如 /gpt4 问题，指令为配置的command或者模型名
+func parseModelPrefix(text string) (model, question string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name := text[1:]
+	if i := strings.IndexAny(name, " \n"); i >= 0 {
+		name, question = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	for model, modelCfg := range config.LoadConfig().Models {
+		if name == model || (modelCfg.Command != "" && name == modelCfg.Command) {
+			return model, question, true
+		}
+	}
+	return "", "", false
+}
+
+// modelNames 可用的模型，默认模型在最前面
+func modelNames() []string {
+	cfg := config.LoadConfig()
+	var names []string
+	for model, modelCfg := range cfg.Models {
+		if model == cfg.Model {
+			continue
+		}
+		if modelCfg.Command != "" {
+			model += "(/" + modelCfg.Command + ")"
+		}
+		names = append(names, model)
+	}
+	sort.Strings(names)
+	return append([]string{cfg.Model}, names...)
+}
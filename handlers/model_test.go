@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// useTestModels 使用指定的默认模型和可选模型，测试结束后恢复配置
+func useTestModels(t *testing.T, model string, models map[string]config.ModelConfiguration) {
+	cfg := config.LoadConfig()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	cfg.Model, cfg.Models, cfg.ModelCommand = model, models, "/model"
+}
+
+func TestParseModelPrefix(t *testing.T) {
+	useTestModels(t, "gpt-3.5-turbo", map[string]config.ModelConfiguration{
+		"gpt-4":  {Command: "gpt4"},
+		"llama3": {},
+	})
+	tests := []struct {
+		text     string
+		model    string
+		question string
+		ok       bool
+	}{
+		{"/gpt4 写一首诗", "gpt-4", "写一首诗", true},
+		{"/gpt-4 写一首诗", "gpt-4", "写一首诗", true},
+		{"/llama3\n第一行\n第二行", "llama3", "第一行\n第二行", true},
+		{"/gpt4", "gpt-4", "", true},
+		{"/gpt4写一首诗", "", "", false},
+		{"/gpt-3.5-turbo 你好", "", "", false},
+		{"gpt4 写一首诗", "", "", false},
+		{"/unknown 你好", "", "", false},
+	}
+	for _, tt := range tests {
+		model, question, ok := parseModelPrefix(tt.text)
+		if model != tt.model || question != tt.question || ok != tt.ok {
+			t.Errorf("parseModelPrefix(%q) = %q, %q, %v, want %q, %q, %v", tt.text, model, question, ok, tt.model, tt.question, tt.ok)
+		}
+	}
+}
+
+func TestHandleModelCommandWithoutModels(t *testing.T) {
+	// 没有配置models时只能使用默认模型
+	useTestModels(t, "gpt-3.5-turbo", nil)
+	store := service.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	userService := service.NewUserService(store, &openwechat.User{Uin: 1})
+
+	if reply, ok := handleModelCommand("/model gpt-4", userService); !ok || !strings.Contains(reply, "不支持模型gpt-4") {
+		t.Errorf("switching to an unconfigured model = %q, %v, want a refusal", reply, ok)
+	}
+	if model := userService.GetUserModel(); model != "" {
+		t.Errorf("user model after a refused switch = %q, want empty", model)
+	}
+	if _, ok := handleModelCommand("/model gpt-3.5-turbo", userService); !ok || currentModel(userService) != "gpt-3.5-turbo" {
+		t.Errorf("switching to the default model = %q, want gpt-3.5-turbo", currentModel(userService))
+	}
+	if _, _, ok := parseModelPrefix("/gpt-4 你好"); ok {
+		t.Errorf("parseModelPrefix without models = true, want false")
+	}
+}
+
+func TestHandleModelCommand(t *testing.T) {
+	useTestModels(t, "gpt-3.5-turbo", map[string]config.ModelConfiguration{"gpt-4": {Command: "gpt4"}})
+	store := service.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	user := &openwechat.User{Uin: 1}
+	userService := service.NewUserService(store, user)
+
+	if reply, ok := handleModelCommand("/model", userService); !ok || !strings.Contains(reply, "可用模型：gpt-3.5-turbo、gpt-4(/gpt4)") {
+		t.Errorf("/model = %q, want the available models", reply)
+	}
+	if reply, ok := handleModelCommand("/model gpt-4", userService); !ok || reply != "之后的提问将使用gpt-4" {
+		t.Errorf("/model gpt-4 = %q, %v", reply, ok)
+	}
+
+	// 切换的模型保存在存储中，清空会话后仍然使用
+	userService.ClearHistory()
+	userService = service.NewUserService(store, user)
+	if settings, question := selectModel("你好", userService); settings.Model != "gpt-4" || question != "你好" {
+		t.Errorf("selectModel after switching = %s, %q, want gpt-4", settings.Model, question)
+	}
+	// 默认模型没有配置在models中，不能单次指定
+	if settings, question := selectModel("/gpt-3.5-turbo 你好", userService); settings.Model != "gpt-4" || question != "/gpt-3.5-turbo 你好" {
+		t.Errorf("selectModel with the default model as prefix = %s, %q, want gpt-4 and the text unchanged", settings.Model, question)
+	}
+
+	// 模型从配置中去掉后使用默认模型
+	config.LoadConfig().Models = nil
+	if model := currentModel(userService); model != "gpt-3.5-turbo" {
+		t.Errorf("current model after removing gpt-4 = %q, want the default", model)
+	}
+
+	handleModelCommand("/model default", userService)
+	if model := userService.GetUserModel(); model != "" {
+		t.Errorf("user model after /model default = %q, want empty", model)
+	}
+}
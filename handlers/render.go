@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/render"
+)
+
+// replyRendered 开启了渲染并且回复中有代码块、表格或公式时，把回复渲染成图片发送，返回是否已发送图片，
+// 渲染或发送失败时只记录日志，照常发送文字
+func replyRendered(msg *openwechat.Message, reply string) bool {
+	cfg := config.LoadConfig()
+	if !cfg.RenderMarkdown || !render.NeedsRender(reply) {
+		return false
+	}
+	data, err := render.Render(reply, render.Options{Width: cfg.RenderWidth, FontFile: cfg.RenderFont})
+	if err != nil {
+		logger.Warning(fmt.Sprintf("render reply error: %v", err))
+		return false
+	}
+	if err = replyImage(msg, data); err != nil {
+		logger.Warning(fmt.Sprintf("reply rendered image error: %v", err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// roleSubcommands 角色指令的子命令，不能用作角色名
+var roleSubcommands = map[string]bool{"add": true, "del": true, "show": true, "global": true, "default": true}
+
+// handleRoleCommand 处理角色指令，私聊对自己生效，群里对整个群生效，不是指令时ok为false：
+// /role 查看当前和可用的角色，/role 角色名 切换，/role default 恢复默认，/role show 角色名 查看设定，
+// /role add 角色名 设定 创建角色，/role del 角色名 删除角色，/role global 角色名|default 设置全局默认角色，
+// 角色是全局共用的，add、del、global只有管理员可以用
+func handleRoleCommand(text string, user *openwechat.User, userService service.UserServiceInterface) (reply string, ok bool) {
+	prefix := config.LoadConfig().RoleCommand
+	fields := strings.Fields(text)
+	if prefix == "" || len(fields) == 0 || fields[0] != prefix {
+		return "", false
+	}
+	store := service.SessionStore()
+	usage := fmt.Sprintf("用法：%s 角色名 切换，%s default 恢复默认，%s show 角色名 查看设定，%s add 角色名 设定 创建角色，%s del 角色名 删除角色",
+		prefix, prefix, prefix, prefix, prefix)
+	if len(fields) == 1 {
+		name, _ := userService.SystemPrompt()
+		if name == "" {
+			name = "无"
+		}
+		roles := "无"
+		if names := service.RoleNames(store); len(names) > 0 {
+			roles = strings.Join(names, "、")
+		}
+		return fmt.Sprintf("当前角色：%s\n可用角色：%s\n%s", name, roles, usage), true
+	}
+
+	switch fields[1] {
+	case "default":
+		userService.SetRole("")
+		name, _ := userService.SystemPrompt()
+		if name == "" {
+			return "已恢复默认，不使用角色设定", true
+		}
+		return "已恢复默认角色" + name, true
+	case "show":
+		if len(fields) < 3 {
+			return usage, true
+		}
+		prompt, exists := service.Roles(store)[fields[2]]
+		if !exists {
+			return "没有角色" + fields[2], true
+		}
+		return fields[2] + "：" + prompt, true
+	case "add":
+		// 设定可以包含空格和换行，取角色名之后的全部内容
+		if len(fields) < 4 {
+			return usage, true
+		}
+		if !isAdmin(user) {
+			return "只有管理员可以创建角色", true
+		}
+		name := fields[2]
+		if roleSubcommands[name] {
+			return name + "是指令关键字，不能用作角色名", true
+		}
+		prompt := text
+		for _, field := range fields[:3] {
+			prompt = strings.TrimSpace(prompt)[len(field):]
+		}
+		prompt = strings.TrimSpace(prompt)
+		if err := service.AddRole(store, name, prompt); err != nil {
+			return err.Error(), true
+		}
+		return fmt.Sprintf("已保存角色%s，发送 %s %s 使用", name, prefix, name), true
+	case "del":
+		if len(fields) < 3 {
+			return usage, true
+		}
+		if !isAdmin(user) {
+			return "只有管理员可以删除角色", true
+		}
+		if err := service.DeleteRole(store, fields[2]); err != nil {
+			return err.Error(), true
+		}
+		return "已删除角色" + fields[2], true
+	case "global":
+		if len(fields) < 3 {
+			return usage, true
+		}
+		if !isAdmin(user) {
+			return "只有管理员可以设置全局默认角色", true
+		}
+		if fields[2] == "default" {
+			service.SetGlobalRole(store, "")
+			return "已恢复配置的默认角色", true
+		}
+		if _, exists := service.Roles(store)[fields[2]]; !exists {
+			return "没有角色" + fields[2], true
+		}
+		service.SetGlobalRole(store, fields[2])
+		return "已把全局默认角色设置为" + fields[2], true
+	}
+
+	name := fields[1]
+	if _, exists := service.Roles(store)[name]; !exists {
+		return fmt.Sprintf("没有角色%s，可用角色：%s", name, strings.Join(service.RoleNames(store), "、")), true
+	}
+	userService.SetRole(name)
+	return "已切换为角色" + name + "，发送清空口令可以清掉之前的上下文", true
+}
+
+// withRole 在上下文最前面加上当前角色的system消息，组装请求时始终保留
+func withRole(history []gpt.Message, userService service.UserServiceInterface) []gpt.Message {
+	_, prompt := userService.SystemPrompt()
+	if prompt == "" {
+		return history
+	}
+	return append([]gpt.Message{{Role: gpt.RoleSystem, Content: prompt}}, history...)
+}
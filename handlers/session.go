@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// handleSessionCommand 处理多会话指令，不是指令时ok为false：
+// /new [名称] 创建并切换，/switch 名称 切换，/sessions 列出，/rename 旧名称 新名称 重命名，/delete 名称 删除
+func handleSessionCommand(text string, userService service.UserServiceInterface) (reply string, ok bool) {
+	commands := config.LoadConfig().SessionCommands
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	var err error
+	switch fields[0] {
+	case commands.New:
+		name := newSessionName(userService.Sessions())
+		if len(fields) > 1 {
+			name = fields[1]
+		}
+		if err = userService.NewSession(name); err == nil {
+			return "已创建并切换到会话" + name, true
+		}
+	case commands.Switch:
+		if len(fields) < 2 {
+			return fmt.Sprintf("用法：%s 名称，%s %s 回到默认会话", commands.Switch, commands.Switch, service.DefaultSessionName), true
+		}
+		if err = userService.SwitchSession(fields[1]); err == nil {
+			return "已切换到会话" + sessionName(userService.ActiveSession()), true
+		}
+	case commands.List:
+		return listSessions(userService.Sessions()), true
+	case commands.Rename:
+		if len(fields) < 3 {
+			return fmt.Sprintf("用法：%s 旧名称 新名称", commands.Rename), true
+		}
+		if err = userService.RenameSession(fields[1], fields[2]); err == nil {
+			return fmt.Sprintf("已把会话%s重命名为%s", fields[1], fields[2]), true
+		}
+	case commands.Delete:
+		if len(fields) < 2 {
+			return fmt.Sprintf("用法：%s 名称", commands.Delete), true
+		}
+		if err = userService.DeleteSession(fields[1]); err == nil {
+			return fmt.Sprintf("已删除会话%s，当前会话：%s", fields[1], sessionName(userService.ActiveSession())), true
+		}
+	default:
+		return "", false
+	}
+	return err.Error(), true
+}
+
+// listSessions 列出会话，当前会话前面加标记
+func listSessions(sessions []service.SessionInfo) string {
+	var b strings.Builder
+	b.WriteString("会话列表：")
+	for _, session := range sessions {
+		mark := "  "
+		if session.Active {
+			mark = "* "
+		}
+		b.WriteString(fmt.Sprintf("\n%s%s（%d条消息）", mark, sessionName(session.Name), session.Turns))
+	}
+	return b.String()
+}
+
+// sessionName 会话的显示名称，默认会话名称为空
+func sessionName(name string) string {
+	if name == "" {
+		return service.DefaultSessionName
+	}
+	return name
+}
+
+// newSessionName 没有指定名称时按序号生成一个没用过的名称
+func newSessionName(sessions []service.SessionInfo) string {
+	used := map[string]bool{}
+	for _, session := range sessions {
+		used[session.Name] = true
+	}
+	for i := len(sessions); ; i++ {
+		name := fmt.Sprintf("会话%d", i)
+		if !used[name] {
+			return name
+		}
+	}
+}
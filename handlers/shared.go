@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// handleSharedCommand 处理群共享上下文指令：/shared [on|off]，不带参数时切换，返回给群的提示，不是指令时ok为false
+func handleSharedCommand(text string, group *openwechat.Group) (reply string, ok bool) {
+	prefix := config.LoadConfig().SharedContextCommand
+	fields := strings.Fields(text)
+	if prefix == "" || len(fields) == 0 || fields[0] != prefix {
+		return "", false
+	}
+	store := service.SessionStore()
+	on := !service.SharedContextEnabled(store, group)
+	if len(fields) > 1 {
+		switch strings.ToLower(fields[1]) {
+		case "on", "开":
+			on = true
+		case "off", "关":
+			on = false
+		default:
+			return fmt.Sprintf("用法：%s [on|off]", prefix), true
+		}
+	}
+	service.SetSharedContext(store, group, on)
+	if on {
+		return "已开启本群共享上下文，大家的发言会带着昵称记入同一份上下文", true
+	}
+	return "已关闭本群共享上下文，每人各自一份上下文", true
+}
+
+// recordGroupMessage 共享上下文的群记下没有@机器人的发言，之后提问时机器人知道群里谁说了什么
+func recordGroupMessage(userService service.UserServiceInterface, sender *openwechat.User, text string) {
+	text = strings.TrimSpace(text)
+	if !userService.Shared() || text == "" {
+		return
+	}
+	userService.AppendHistory(service.NewSpeakerTurn(sender.NickName, text))
+}
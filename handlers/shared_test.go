@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+func TestRecordGroupMessage(t *testing.T) {
+	cfg := config.LoadConfig()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	cfg.SharedContextGroups = []string{"共享群"}
+
+	store := service.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	shared := &openwechat.Group{User: &openwechat.User{Uin: 100, NickName: "共享群"}}
+	private := &openwechat.Group{User: &openwechat.User{Uin: 200, NickName: "普通群"}}
+	alice := &openwechat.User{Uin: 1, NickName: "小明"}
+	bob := &openwechat.User{Uin: 2, NickName: "小红"}
+
+	// 共享上下文的群记下每个人没有@机器人的发言，带上昵称，空白消息不记
+	recordGroupMessage(service.NewGroupUserService(store, alice, shared), alice, " 今晚吃什么 ")
+	recordGroupMessage(service.NewGroupUserService(store, bob, shared), bob, "火锅")
+	recordGroupMessage(service.NewGroupUserService(store, bob, shared), bob, "  ")
+	history := service.NewGroupUserService(store, bob, shared).GetHistory()
+	messages := service.Messages(history)
+	if len(messages) != 2 || messages[0].Content != "小明：今晚吃什么" || messages[1].Content != "小红：火锅" {
+		t.Fatalf("shared history = %+v, want both messages with names", messages)
+	}
+	if history[0].Name != "小明" || history[0].Role != gpt.RoleUser {
+		t.Errorf("recorded turn = %+v, want a user turn from 小明", history[0])
+	}
+
+	// 没有开启共享的群不记
+	recordGroupMessage(service.NewGroupUserService(store, alice, private), alice, "今晚吃什么")
+	if history := service.NewGroupUserService(store, alice, private).GetHistory(); len(history) != 0 {
+		t.Errorf("history in a group without shared context = %+v, want empty", history)
+	}
+
+	// 群里用指令关闭后以设置为准
+	service.SetSharedContext(store, shared, false)
+	if service.SharedContextEnabled(store, shared) {
+		t.Fatalf("SharedContextEnabled after turning it off = true, want false")
+	}
+	recordGroupMessage(service.NewGroupUserService(store, alice, shared), alice, "不记了")
+	if history := service.NewGroupUserService(store, alice, shared).GetHistory(); len(history) != 0 {
+		t.Errorf("own history after turning shared context off = %+v, want empty", history)
+	}
+}
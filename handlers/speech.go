@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// speechEnabled 是否用语音回复，私聊按用户ID，群聊按群ID
+func speechEnabled(id string) bool {
+	return service.SpeechReplyEnabled(service.SessionStore(), id)
+}
+
+// parseSpeechCommand 解析语音回复指令：/voice [on|off]，不带参数时toggle为true，不是指令时ok为false
+func parseSpeechCommand(text string) (on, toggle, ok bool, err error) {
+	prefix := config.LoadConfig().SpeechCommand
+	fields := strings.Fields(text)
+	if prefix == "" || len(fields) == 0 || fields[0] != prefix {
+		return false, false, false, nil
+	}
+	if len(fields) == 1 {
+		return false, true, true, nil
+	}
+	switch strings.ToLower(fields[1]) {
+	case "on", "开":
+		return true, false, true, nil
+	case "off", "关":
+		return false, false, true, nil
+	default:
+		return false, false, true, fmt.Errorf("用法：%s [on|off]", prefix)
+	}
+}
+
+// handleSpeechCommand 处理语音回复开关指令，开关保存在会话存储中，返回给用户的提示，不是指令时ok为false
+func handleSpeechCommand(text, id string) (reply string, ok bool) {
+	on, toggle, ok, err := parseSpeechCommand(text)
+	if !ok {
+		return "", false
+	}
+	if err != nil {
+		return err.Error(), true
+	}
+	store := service.SessionStore()
+	if toggle {
+		on = service.ToggleSpeechReply(store, id)
+	} else {
+		service.SetSpeechReply(store, id, on)
+	}
+	if on {
+		return "已开启语音回复，回答会以语音文件发送，太长时仍然发文字", true
+	}
+	return "已关闭语音回复", true
+}
+
+// replySpeech 把回复合成语音后以mp3文件发送，回复太长或合成失败时返回false，由调用方改发文字
+func replySpeech(ctx context.Context, msg *openwechat.Message, reply string) bool {
+	reply = strings.TrimSpace(reply)
+	if reply == "" || utf8.RuneCountInString(reply) > config.LoadConfig().SpeechMaxLength {
+		return false
+	}
+	ctx, cancel := withCallTimeout(ctx)
+	defer cancel()
+	audio, err := gpt.Speech(ctx, reply)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("gpt speech error: %v", err))
+		return false
+	}
+	if err = replyFile(msg, audio, "wechatbot-*.mp3"); err != nil {
+		logger.Warning(fmt.Sprintf("reply speech error: %v", err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+const (
+	// codeFence markdown代码块标记
+	codeFence = "```"
+	// partNumberReserve 给序号预留的字符数，如 (1/3)
+	partNumberReserve = 10
+	// fenceReserve 拆开代码块时给补上的代码块标记预留的字符数
+	fenceReserve = 20
+)
+
+// sentenceEnds 句子结束的标点，超长段落按句子拆分
+const sentenceEnds = "。！？；.!?;"
+
+// replyLongText 按配置的长度拆分回复并逐条发送，多条时加上序号，第一条经decorate加上前缀（如@和问题），
+// 第一条的长度要减去前缀
+func replyLongText(ctx context.Context, msg *openwechat.Message, text string, decorate func(string) string) error {
+	limit := config.LoadConfig().ReplyMaxLength
+	if limit > partNumberReserve*2 {
+		limit -= partNumberReserve
+	}
+	parts := splitReply(text, limit, decorationLength(decorate))
+	if len(parts) == 0 {
+		parts = []string{""}
+	}
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+		}
+	}
+	return sendParts(ctx, msg, parts, decorate)
+}
+
+// sendParts 逐条发送，第一条经decorate处理，每条之间随机间隔一段时间降低风控风险，
+// 用户取消时不再发送剩下的部分，请求超时不影响已经拿到的回复
+func sendParts(ctx context.Context, msg *openwechat.Message, parts []string, decorate func(string) string) error {
+	interval := time.Second * config.LoadConfig().ReplyInterval
+	for i, part := range parts {
+		if i == 0 && decorate != nil {
+			part = decorate(part)
+		}
+		if i > 0 && interval > 0 {
+			wait := interval + time.Duration(rand.Int63n(int64(interval)))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.Canceled) {
+					timer.Stop()
+					return nil
+				}
+				<-timer.C
+			}
+		}
+		if _, err := msg.ReplyText(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitReply 把回复拆成不超过limit个字符的多段：优先在段落之间拆分，超长段落按行和句子拆分，
+// 拆开的代码块在每段末尾补上结束标记、下一段开头补上开始标记，limit不大于0时不拆分。
+// 第一段还要加上reserve个字符的前缀，可用的长度相应减少，前缀本身超长时第一段至少放1个字符
+func splitReply(text string, limit, reserve int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	firstLimit := limit - reserve
+	if firstLimit < 1 {
+		firstLimit = 1
+	}
+	if limit <= 0 || utf8.RuneCountInString(text) <= firstLimit {
+		return []string{text}
+	}
+
+	var parts []string
+	var current string
+	// partLimit 当前这一段可用的长度
+	partLimit := func() int {
+		if len(parts) == 0 {
+			return firstLimit
+		}
+		return limit
+	}
+	for _, block := range splitBlocks(text) {
+		pieces := []string{block}
+		if utf8.RuneCountInString(block) > limit {
+			pieces = splitLines(block, limit)
+		}
+		for len(pieces) > 0 {
+			piece := pieces[0]
+			pieces = pieces[1:]
+			if current != "" && utf8.RuneCountInString(current)+2+utf8.RuneCountInString(piece) > partLimit() {
+				parts = append(parts, current)
+				current = ""
+			}
+			// 只有第一段会比拆分时用的长度短，放不下的再拆小，剩下的留给后面
+			if current == "" && utf8.RuneCountInString(piece) > partLimit() {
+				piece, pieces = splitFirst(piece, partLimit(), limit, pieces)
+			}
+			if current == "" {
+				current = piece
+			} else {
+				current += "\n\n" + piece
+			}
+		}
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// splitFirst 从piece中拆出不超过firstLimit的开头，剩下的按limit拆分后放回pieces前面
+func splitFirst(piece string, firstLimit, limit int, pieces []string) (string, []string) {
+	smaller := splitLines(piece, firstLimit)
+	if len(smaller) == 0 {
+		return piece, pieces
+	}
+	first := smaller[0]
+	// 不含代码块时剩下的部分就是原文去掉开头，按正常长度重新拆分，不会在句子中间多出空行
+	if !strings.Contains(piece, codeFence) && strings.HasPrefix(piece, first) {
+		rest := strings.TrimLeft(piece[len(first):], "\n")
+		if rest == "" {
+			return first, pieces
+		}
+		restPieces := []string{rest}
+		if utf8.RuneCountInString(rest) > limit {
+			restPieces = splitLines(rest, limit)
+		}
+		return first, append(restPieces, pieces...)
+	}
+	return first, append(smaller[1:], pieces...)
+}
+
+// decorationLength decorate给第一段加上的字符数，如群里的@、问题和分隔线，没有decorate时为0
+func decorationLength(decorate func(string) string) int {
+	if decorate == nil {
+		return 0
+	}
+	const sample = "x"
+	return utf8.RuneCountInString(decorate(sample)) - utf8.RuneCountInString(sample)
+}
+
+// splitBlocks 按代码块以外的空行拆成段落，代码块中的空行不拆
+func splitBlocks(text string) []string {
+	var blocks []string
+	var lines []string
+	inFence := false
+	for _, line := range strings.Split(text, "\n") {
+		if isFenceLine(line) {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				blocks = append(blocks, strings.Join(lines, "\n"))
+				lines = nil
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return blocks
+}
+
+// splitLines 按行拆分超长段落，超长的行再按句子拆分，在代码块中间拆开时补上代码块标记
+func splitLines(block string, limit int) []string {
+	lineLimit := limit - fenceReserve
+	if lineLimit < 1 {
+		lineLimit = limit
+	}
+
+	var pieces []string
+	var lines []string
+	length := 0
+	// 当前所在代码块的开始标记，如 ```go，不在代码块中时为空
+	fence := ""
+	flush := func() {
+		piece := strings.Join(lines, "\n")
+		if fence != "" {
+			piece += "\n" + codeFence
+		}
+		pieces = append(pieces, piece)
+		lines, length = nil, 0
+		if fence != "" {
+			lines, length = []string{fence}, utf8.RuneCountInString(fence)+1
+		}
+	}
+	for _, line := range strings.Split(block, "\n") {
+		for _, segment := range splitSentences(line, lineLimit) {
+			base := 0
+			if fence != "" {
+				base = 1
+			}
+			if len(lines) > base && length+utf8.RuneCountInString(segment)+len(codeFence)+1 > limit {
+				flush()
+			}
+			lines = append(lines, segment)
+			length += utf8.RuneCountInString(segment) + 1
+		}
+		if isFenceLine(line) {
+			if fence == "" {
+				fence = strings.TrimSpace(line)
+			} else {
+				fence = ""
+			}
+		}
+	}
+	// 只剩补上的代码块开始标记时不再单独成段
+	if len(lines) > 0 && !(fence != "" && len(lines) == 1) {
+		flush()
+	}
+	return pieces
+}
+
+// splitSentences 按句子拆分超长的行，单个句子仍然超长时按字符截断
+func splitSentences(line string, limit int) []string {
+	if utf8.RuneCountInString(line) <= limit {
+		return []string{line}
+	}
+	var segments []string
+	var current []rune
+	var sentence []rune
+	appendSentence := func() {
+		if len(current)+len(sentence) > limit && len(current) > 0 {
+			segments = append(segments, string(current))
+			current = nil
+		}
+		for len(sentence) > limit {
+			segments = append(segments, string(sentence[:limit]))
+			sentence = sentence[limit:]
+		}
+		current = append(current, sentence...)
+		sentence = nil
+	}
+	for _, r := range line {
+		sentence = append(sentence, r)
+		if strings.ContainsRune(sentenceEnds, r) {
+			appendSentence()
+		}
+	}
+	appendSentence()
+	if len(current) > 0 {
+		segments = append(segments, string(current))
+	}
+	return segments
+}
+
+// isFenceLine 是否为代码块的开始或结束行
+func isFenceLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), codeFence)
+}
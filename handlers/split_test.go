@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"empty", "  \n ", 10, nil},
+		{"short", " 你好 ", 10, []string{"你好"}},
+		{"no limit", "一二三四五六", 0, []string{"一二三四五六"}},
+		{"merge paragraphs", "一二三\n\n四五六\n\n七八九", 8, []string{"一二三\n\n四五六", "七八九"}},
+		{"extra blank lines", "一二三\n\n\n\n四五六", 5, []string{"一二三", "四五六"}},
+		{"sentences", "一二三。四五六。七八九。", 8, []string{"一二三。四五六。", "七八九。"}},
+		{"long sentence", "一二三四五六七八九十", 4, []string{"一二三四", "五六七八", "九十"}},
+	}
+	for _, tt := range tests {
+		if got := splitReply(tt.text, tt.limit, 0); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitReply = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitReplyCodeBlock(t *testing.T) {
+	var code []string
+	for i := 0; i < 20; i++ {
+		code = append(code, "fmt.Println(\"line\")")
+	}
+	text := "说明\n\n```go\n" + strings.Join(code[:10], "\n") + "\n\n" + strings.Join(code[10:], "\n") + "\n```\n\n结束"
+	limit := 100
+	parts := splitReply(text, limit, 0)
+	if len(parts) < 3 {
+		t.Fatalf("splitReply returned %d parts, want the code block split", len(parts))
+	}
+	lines := 0
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > limit {
+			t.Errorf("part %d has %d characters, limit %d", i, n, limit)
+		}
+		// 每一段中的代码块都是闭合的，拆开后接着的一段补上开始标记
+		if strings.Count(part, codeFence)%2 != 0 {
+			t.Errorf("part %d has an unclosed code block:\n%s", i, part)
+		}
+		if strings.Contains(part, "fmt.Println") && !strings.Contains(part, "```go") {
+			t.Errorf("part %d lost the code block language:\n%s", i, part)
+		}
+		lines += strings.Count(part, "fmt.Println")
+	}
+	if lines != len(code) {
+		t.Errorf("parts contain %d code lines, want %d", lines, len(code))
+	}
+	if !strings.HasPrefix(parts[0], "说明") || !strings.HasSuffix(parts[len(parts)-1], "结束") {
+		t.Errorf("text around the code block moved: first %q, last %q", parts[0], parts[len(parts)-1])
+	}
+}
+
+func TestSplitBlocks(t *testing.T) {
+	text := "一\n\n```\na\n\nb\n```\n\n二"
+	want := []string{"一", "```\na\n\nb\n```", "二"}
+	if got := splitBlocks(text); !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitBlocks = %q, want %q", got, want)
+	}
+}
+
+func TestSplitReplyWithPrefix(t *testing.T) {
+	// 和群里的回复一样，第一段前面加上@、问题和分隔线
+	decorate := func(text string) string {
+		return "@小明\n今天天气怎么样\n" + strings.Repeat("-", 36) + "\n" + text
+	}
+	reserve := decorationLength(decorate)
+	if want := utf8.RuneCountInString(decorate("")); reserve != want {
+		t.Fatalf("decorationLength = %d, want %d", reserve, want)
+	}
+
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits with prefix", "晴。", 60, []string{"晴。"}},
+		{"fits only without prefix", "一二三四五六七八九十。一二三四五六七八九十。", 60, []string{"一二三四五六七八九十。", "一二三四五六七八九十。"}},
+		{"paragraphs", "一二三四五\n\n六七八九十\n\n一二三四五", 60, []string{"一二三四五", "六七八九十\n\n一二三四五"}},
+		{"prefix longer than limit", "一二三", 40, []string{"一", "二三"}},
+	}
+	for _, tt := range tests {
+		got := splitReply(tt.text, tt.limit, reserve)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitReply = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 第一段加上前缀后也不超过上限
+	text := strings.Repeat("一句话。", 100)
+	for _, limit := range []int{80, 100, 200} {
+		parts := splitReply(text, limit, reserve)
+		if n := utf8.RuneCountInString(decorate(parts[0])); n > limit {
+			t.Errorf("limit %d: first part with prefix has %d characters", limit, n)
+		}
+		for i, part := range parts[1:] {
+			if n := utf8.RuneCountInString(part); n > limit {
+				t.Errorf("limit %d: part %d has %d characters", limit, i+1, n)
+			}
+		}
+		if joined := strings.ReplaceAll(strings.Join(parts, ""), "\n", ""); joined != text {
+			t.Errorf("limit %d: parts lost text", limit)
+		}
+	}
+
+	// 代码块开头就超长时，第一段拆出的部分也补上代码块标记
+	code := "```go\n" + strings.Repeat("fmt.Println(\"line\")\n", 10) + "```"
+	parts := splitReply(code, 120, reserve)
+	if n := utf8.RuneCountInString(decorate(parts[0])); n > 120 {
+		t.Errorf("code block: first part with prefix has %d characters", n)
+	}
+	for i, part := range parts {
+		if strings.Count(part, codeFence)%2 != 0 {
+			t.Errorf("code block: part %d has an unclosed code block:\n%s", i, part)
+		}
+	}
+
+	if got := decorationLength(nil); got != 0 {
+		t.Errorf("decorationLength(nil) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// streamReplier 流式回复，按段落把累计的文本分批发送到微信
+type streamReplier struct {
+	// 发送一段文本，first表示是否为第一段
+	send func(text string, first bool) error
+	// 一段至少累计多少个字符才发送
+	minLength int
+	// 尚未发送的文本
+	buffer strings.Builder
+	// 已发送的段数
+	sent int
+}
+
+// newStreamReplier 创建流式回复
+func newStreamReplier(minLength int, send func(text string, first bool) error) *streamReplier {
+	return &streamReplier{send: send, minLength: minLength}
+}
+
+// Write 追加增量文本，累计到一个完整段落并且长度足够时发送
+func (s *streamReplier) Write(delta string) error {
+	s.buffer.WriteString(delta)
+	text := s.buffer.String()
+
+	// 1.以最后一个空行作为段落边界
+	index := strings.LastIndex(text, "\n\n")
+	if index < 0 {
+		return nil
+	}
+	paragraph := text[:index]
+
+	// 2.长度不够或者处在未闭合的代码块中不发送
+	if utf8.RuneCountInString(paragraph) < s.minLength || strings.Count(paragraph, "```")%2 != 0 {
+		return nil
+	}
+
+	s.buffer.Reset()
+	s.buffer.WriteString(text[index+2:])
+	return s.emit(paragraph)
+}
+
+// Flush 流结束时发送剩余文本
+func (s *streamReplier) Flush() error {
+	text := s.buffer.String()
+	s.buffer.Reset()
+	return s.emit(text)
+}
+
+// Sent 是否已经发送过内容
+func (s *streamReplier) Sent() bool {
+	return s.sent > 0
+}
+
+func (s *streamReplier) emit(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	s.sent++
+	return s.send(text, s.sent == 1)
+}
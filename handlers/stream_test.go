@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamReplier(t *testing.T) {
+	tests := []struct {
+		name      string
+		minLength int
+		deltas    []string
+		want      []string
+	}{
+		{
+			name:      "paragraphs",
+			minLength: 1,
+			deltas:    []string{"第一段", "\n\n第二", "段\n\n第三段"},
+			want:      []string{"第一段", "第二段", "第三段"},
+		},
+		{
+			name:      "waits for min length",
+			minLength: 6,
+			deltas:    []string{"一二三\n\n", "四五六\n\n", "七"},
+			want:      []string{"一二三\n\n四五六", "七"},
+		},
+		{
+			name:      "keeps code block together",
+			minLength: 1,
+			deltas:    []string{"```go\na := 1\n\n", "b := 2\n```\n\n", "结束"},
+			want:      []string{"```go\na := 1\n\nb := 2\n```", "结束"},
+		},
+		{
+			name:      "no paragraph break",
+			minLength: 1,
+			deltas:    []string{"一", "二", "三"},
+			want:      []string{"一二三"},
+		},
+		{
+			name:      "blank only",
+			minLength: 1,
+			deltas:    []string{"\n\n", "  "},
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		var sent []string
+		var firsts []bool
+		replier := newStreamReplier(tt.minLength, func(text string, first bool) error {
+			sent = append(sent, text)
+			firsts = append(firsts, first)
+			return nil
+		})
+		for _, delta := range tt.deltas {
+			if err := replier.Write(delta); err != nil {
+				t.Fatalf("%s: Write error: %v", tt.name, err)
+			}
+		}
+		if err := replier.Flush(); err != nil {
+			t.Fatalf("%s: Flush error: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(sent, tt.want) {
+			t.Errorf("%s: sent %q, want %q", tt.name, sent, tt.want)
+		}
+		if replier.Sent() != (len(tt.want) > 0) {
+			t.Errorf("%s: Sent() = %v", tt.name, replier.Sent())
+		}
+		for i, first := range firsts {
+			if first != (i == 0) {
+				t.Errorf("%s: segment %d first = %v", tt.name, i, first)
+			}
+		}
+	}
+}
+
+func TestStreamReplierSendError(t *testing.T) {
+	failed := errors.New("send failed")
+	replier := newStreamReplier(1, func(text string, first bool) error {
+		return failed
+	})
+	if err := replier.Write(strings.Repeat("字", 3) + "\n\n"); !errors.Is(err, failed) {
+		t.Fatalf("Write error = %v, want %v", err, failed)
+	}
+}
@@ -1,12 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"github.com/eatmoreapple/openwechat"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
 	"github.com/qingconglaixueit/wechatbot/service"
-	"math/rand"
-	"time"
 )
 
 var _ MessageHandlerInterface = (*TokenMessageHandler)(nil)
@@ -21,17 +20,18 @@ type TokenMessageHandler struct {
 	service service.UserServiceInterface
 }
 
-func TokenMessageContextHandler() func(ctx *openwechat.MessageContext) {
+func TokenMessageContextHandler(parent context.Context) func(ctx *openwechat.MessageContext) {
 	return func(ctx *openwechat.MessageContext) {
 		msg := ctx.Message
 		// 获取口令消息处理器
 		handler, err := NewTokenMessageHandler(msg)
 		if err != nil {
 			logger.Warning(fmt.Sprintf("init token message handler error: %s", err))
+			return
 		}
 
 		// 获取口令消息处理器
-		err = handler.handle()
+		err = handler.handle(parent)
 		if err != nil {
 			logger.Warning(fmt.Sprintf("handle token message error: %s", err))
 		}
@@ -45,10 +45,16 @@ func NewTokenMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, e
 	if err != nil {
 		return nil, err
 	}
+	// 群里清空的是自己的上下文，共享上下文的群清空全群的上下文
+	userService := service.NewUserService(service.SessionStore(), sender)
 	if msg.IsComeFromGroup() {
+		group := &openwechat.Group{User: sender}
 		sender, err = msg.SenderInGroup()
+		if err != nil {
+			return nil, err
+		}
+		userService = service.NewGroupUserService(service.SessionStore(), sender, group)
 	}
-	userService := service.NewUserService(c, sender)
 	handler := &TokenMessageHandler{
 		msg:     msg,
 		sender:  sender,
@@ -59,25 +65,37 @@ func NewTokenMessageHandler(msg *openwechat.Message) (MessageHandlerInterface, e
 }
 
 // handle 处理口令
-func (t *TokenMessageHandler) handle() error {
-	return t.ReplyText()
+func (t *TokenMessageHandler) handle(ctx context.Context) error {
+	return t.ReplyText(ctx)
 }
 
-// ReplyText 回复清空口令
-func (t *TokenMessageHandler) ReplyText() error {
-	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
-	time.Sleep(time.Duration(maxInt+1) * time.Second)
+// ReplyText 回复清空、取消口令
+func (t *TokenMessageHandler) ReplyText(ctx context.Context) error {
+	// 1.取消口令立即取消进行中的请求，不用等待
+	if isCancelToken(t.msg.Content) {
+		text := "当前没有进行中的回答"
+		if requests.cancel(t.sender.ID()) {
+			text = "已取消本次回答"
+		}
+		return t.reply(text)
+	}
+
+	// 2.清空会话
+	if err := sleepRandom(ctx); err != nil {
+		return err
+	}
+	t.service.ClearHistory()
+	return t.reply("strongant 自费购买了ChatGPT Plus 服务，已使用GPT3.5模型，上下文已经清空，请问下个问题！")
+}
 
-	t.service.ClearUserSessionContext()
-	var err error
+// reply 回复口令处理结果，群里只回复@我的消息并带上@
+func (t *TokenMessageHandler) reply(text string) error {
 	if t.msg.IsComeFromGroup() {
 		if !t.msg.IsAt() {
-			return err
+			return nil
 		}
-		atText := "@" + t.sender.NickName + "strongant 自费购买了ChatGPT Plus 服务，已使用GPT3.5模型，上下文已经清空，请问下个问题！"
-		_, err = t.msg.ReplyText(atText)
-	} else {
-		_, err = t.msg.ReplyText("strongant 自费购买了ChatGPT Plus 服务，已使用GPT3.5模型，上下文已经清空，请问下个问题！")
+		text = "@" + t.sender.NickName + text
 	}
+	_, err := t.msg.ReplyText(text)
 	return err
 }
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+// maxUsageReportItems 用量排行最多显示的条数
+const maxUsageReportItems = 20
+
+// recordUsage 记录一次请求的用量，群聊同时计入群
+func recordUsage(usage gpt.Usage, user *openwechat.User, group *openwechat.Group) {
+	if usage.PromptTokens+usage.CompletionTokens == 0 {
+		return
+	}
+	var groupID, groupName string
+	if group != nil {
+		groupID, groupName = group.ID(), group.NickName
+	}
+	service.Ledger().Record(usage, user.ID(), user.NickName, groupID, groupName)
+}
+
+// handleUsageCommand 处理用量查询指令：/usage 查看自己的用量，群里同时显示群的用量，
+// 管理员发送 /usage all 查看今天所有人的用量，不是指令时ok为false
+func handleUsageCommand(text string, user *openwechat.User, group *openwechat.Group) (reply string, ok bool) {
+	prefix := config.LoadConfig().UsageCommand
+	fields := strings.Fields(text)
+	if prefix == "" || len(fields) == 0 || fields[0] != prefix {
+		return "", false
+	}
+	if len(fields) > 1 {
+		if fields[1] != "all" {
+			return fmt.Sprintf("用法：%s [all]", prefix), true
+		}
+		if !isAdmin(user) {
+			return "只有管理员可以查看所有人的用量", true
+		}
+		return usageReport(time.Now()), true
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	ledger := service.Ledger()
+	lines := []string{
+		"你的ID：" + user.ID(),
+		"今天：" + formatUsage(ledger.Sum(service.UserUsageKey(user.ID()), today)),
+		"本月：" + formatUsage(ledger.Sum(service.UserUsageKey(user.ID()), month)),
+	}
+	if group != nil {
+		lines = append(lines,
+			"本群今天："+formatUsage(ledger.Sum(service.GroupUsageKey(group.ID()), today)),
+			"本群本月："+formatUsage(ledger.Sum(service.GroupUsageKey(group.ID()), month)),
+		)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// usageReport 某天所有用户和群的用量排行
+func usageReport(date time.Time) string {
+	entries := service.Ledger().Report(date)
+	if len(entries) == 0 {
+		return "今天还没有用量"
+	}
+	var total service.UsageRecord
+	var lines []string
+	for _, entry := range entries {
+		name := entry.Name
+		if strings.HasPrefix(entry.Key, "group:") {
+			name = "[群]" + name
+		} else {
+			// 群成员的用量同时计入了群，总计只按用户统计
+			total.Requests += entry.Requests
+			total.PromptTokens += entry.PromptTokens
+			total.CompletionTokens += entry.CompletionTokens
+			total.Cost += entry.Cost
+		}
+		if len(lines) < maxUsageReportItems {
+			lines = append(lines, name+"："+formatUsage(entry.UsageRecord))
+		}
+	}
+	header := date.Format("2006-01-02") + " 总计：" + formatUsage(total)
+	return header + "\n" + strings.Join(lines, "\n")
+}
+
+// formatUsage 格式化用量
+func formatUsage(record service.UsageRecord) string {
+	return fmt.Sprintf("%d次，提问%d tokens，回复%d tokens，约$%.4f",
+		record.Requests, record.PromptTokens, record.CompletionTokens, record.Cost)
+}
+
+// isAdmin 是否为管理员，按用户ID、备注或昵称匹配
+func isAdmin(user *openwechat.User) bool {
+	for _, admin := range config.LoadConfig().Admins {
+		if admin != "" && (admin == user.ID() || admin == user.RemarkName || admin == user.NickName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/service"
+)
+
+func TestHandleUsageCommand(t *testing.T) {
+	cfg := config.LoadConfig()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	// 账本只记在内存中
+	cfg.UsageFile, cfg.UsageCommand = "", "/usage"
+	cfg.Admins = []string{"2", "管理员备注"}
+
+	user := &openwechat.User{Uin: 1, NickName: "小明"}
+	group := &openwechat.Group{User: &openwechat.User{Uin: 100, NickName: "测试群"}}
+	service.Ledger().Record(gpt.Usage{Model: "gpt-4", PromptTokens: 10, CompletionTokens: 20}, user.ID(), user.NickName, group.ID(), group.NickName)
+
+	tests := []struct {
+		name   string
+		text   string
+		user   *openwechat.User
+		group  *openwechat.Group
+		ok     bool
+		want   string
+		reject string
+	}{
+		{"not a command", "/usages", user, nil, false, "", ""},
+		{"own usage", "/usage", user, nil, true, "你的ID：1", "本群"},
+		{"own usage in group", "/usage", user, group, true, "本群今天：1次", ""},
+		{"bad argument", "/usage today", user, nil, true, "用法：/usage [all]", ""},
+		{"all by non admin", "/usage all", user, nil, true, "只有管理员", "总计"},
+		{"all by admin id", "/usage all", &openwechat.User{Uin: 2}, nil, true, "[群]测试群", ""},
+		{"all by admin remark", "/usage all", &openwechat.User{Uin: 3, RemarkName: "管理员备注"}, nil, true, "小明：1次", ""},
+	}
+	for _, tt := range tests {
+		reply, ok := handleUsageCommand(tt.text, tt.user, tt.group)
+		if ok != tt.ok || !strings.Contains(reply, tt.want) || (tt.reject != "" && strings.Contains(reply, tt.reject)) {
+			t.Errorf("%s: handleUsageCommand(%q) = %q, %v, want %v containing %q", tt.name, tt.text, reply, ok, tt.ok, tt.want)
+		}
+	}
+
+	// 没有配置指令时不处理
+	cfg.UsageCommand = ""
+	if _, ok := handleUsageCommand("/usage", user, nil); ok {
+		t.Errorf("handleUsageCommand with the command disabled = true, want false")
+	}
+}
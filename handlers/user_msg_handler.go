@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -11,6 +11,7 @@ import (
 	"github.com/qingconglaixueit/wechatbot/config"
 	"github.com/qingconglaixueit/wechatbot/gpt"
 	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	"github.com/qingconglaixueit/wechatbot/pkg/render"
 	"github.com/qingconglaixueit/wechatbot/service"
 )
 
@@ -24,18 +25,25 @@ type UserMessageHandler struct {
 	sender *openwechat.User
 	// 实现的用户业务
 	service service.UserServiceInterface
+	// 重新回答的上一轮问答，回答后替换它
+	retried []service.Turn
+	// 本次提问记入上下文的消息
+	questionTurn service.Turn
+	// 本次提问带的图片
+	questionImages [][]byte
 }
 
-func UserMessageContextHandler() func(ctx *openwechat.MessageContext) {
+func UserMessageContextHandler(parent context.Context) func(ctx *openwechat.MessageContext) {
 	return func(ctx *openwechat.MessageContext) {
 		msg := ctx.Message
 		handler, err := NewUserMessageHandler(msg)
 		if err != nil {
 			logger.Warning(fmt.Sprintf("init user message handler error: %s", err))
+			return
 		}
 
 		// 处理用户消息
-		err = handler.handle()
+		err = handler.handle(parent)
 		if err != nil {
 			logger.Warning(fmt.Sprintf("handle user message error: %s", err))
 		}
@@ -48,7 +56,7 @@ func NewUserMessageHandler(message *openwechat.Message) (MessageHandlerInterface
 	if err != nil {
 		return nil, err
 	}
-	userService := service.NewUserService(c, sender)
+	userService := service.NewUserService(service.SessionStore(), sender)
 	handler := &UserMessageHandler{
 		msg:     message,
 		sender:  sender,
@@ -59,43 +67,200 @@ func NewUserMessageHandler(message *openwechat.Message) (MessageHandlerInterface
 }
 
 // handle 处理消息
-func (h *UserMessageHandler) handle() error {
+func (h *UserMessageHandler) handle(ctx context.Context) error {
 	if h.msg.IsText() {
-		return h.ReplyText()
+		return h.ReplyText(ctx)
+	}
+	if h.msg.IsVoice() && config.LoadConfig().Voice {
+		return h.ReplyVoice(ctx)
+	}
+	if h.msg.IsPicture() && config.LoadConfig().Vision {
+		return h.ReceivePicture()
 	}
 	return nil
 }
 
+// ReceivePicture 保存收到的图片，接下来的提问会带上图片
+func (h *UserMessageHandler) ReceivePicture() error {
+	if time.Now().Unix()-h.msg.CreateTime > 60 {
+		return nil
+	}
+	log.Printf("Received User[%v] picture, CreateTime[%v]", h.sender.NickName,
+		time.Unix(h.msg.CreateTime, 0).Format("2006/01/02 15:04:05"))
+
+	image, err := downloadPicture(h.msg)
+	if err != nil {
+		return err
+	}
+	h.service.AddUserImage(image)
+	_, err = h.msg.ReplyText(pictureReceivedText)
+	return err
+}
+
 // ReplyText 发送文本消息到群
-func (h *UserMessageHandler) ReplyText() error {
+func (h *UserMessageHandler) ReplyText(ctx context.Context) error {
 	if time.Now().Unix()-h.msg.CreateTime > 60 {
 		return nil
 	}
 
-	maxInt := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(5)
-	time.Sleep(time.Duration(maxInt+1) * time.Second)
+	// 请求可以被取消口令、超时或者退出登录取消
+	cfg := config.LoadConfig()
+	ctx, done := requests.start(ctx, h.sender.ID(), requestTimeout(cfg))
+	defer done()
+	if err := sleepRandom(ctx); err != nil {
+		return nil
+	}
 
 	log.Printf("Received User[%v], Content[%v], CreateTime[%v]", h.sender.NickName, h.msg.Content,
 		time.Unix(h.msg.CreateTime, 0).Format("2006/01/02 15:04:05"))
 
-	var (
-		reply string
-		err   error
-	)
-	// 1.获取上下文，如果字符串为空不处理
+	// 1.获取请求文本，如果字符串为空不处理
 	requestText := h.getRequestText()
 	if requestText == "" {
 		log.Println("user message is empty")
 		return nil
 	}
+	return h.reply(ctx, requestText)
+}
+
+// ReplyVoice 语音消息转成文字，回显识别内容后按文字提问回复
+func (h *UserMessageHandler) ReplyVoice(ctx context.Context) error {
+	if time.Now().Unix()-h.msg.CreateTime > 60 {
+		return nil
+	}
+
+	cfg := config.LoadConfig()
+	ctx, done := requests.start(ctx, h.sender.ID(), requestTimeout(cfg))
+	defer done()
+	if err := sleepRandom(ctx); err != nil {
+		return nil
+	}
+
+	log.Printf("Received User[%v] voice, CreateTime[%v]", h.sender.NickName,
+		time.Unix(h.msg.CreateTime, 0).Format("2006/01/02 15:04:05"))
 
-	// 2.向GPT发起请求，如果回复文本等于空,不回复
-	reply, err = gpt.Completions(h.getRequestText())
+	// 1.语音转文字
+	requestText, err := transcribeVoice(ctx, h.msg)
 	if err != nil {
-		text := err.Error()
-		if strings.Contains(err.Error(), "context deadline exceeded") {
-			text = deadlineExceededText
+		logger.Warning(fmt.Sprintf("transcribe voice error: %v", err))
+		if gpt.ErrorKindOf(err) == gpt.ErrorCanceled {
+			return nil
 		}
+		_, err = h.msg.ReplyText(voiceErrorReplyText(err))
+		return err
+	}
+	if requestText == "" {
+		_, err = h.msg.ReplyText(voiceUnclearText)
+		return err
+	}
+	log.Println("voice transcript:" + requestText)
+
+	// 2.回显识别内容，再按文字提问处理
+	if _, err = h.msg.ReplyText(buildVoiceEcho(requestText)); err != nil {
+		return fmt.Errorf("reply user error: %v ", err)
+	}
+	return h.reply(ctx, requestText)
+}
+
+// reply 处理一次提问，文字和语音共用
+func (h *UserMessageHandler) reply(ctx context.Context, requestText string) error {
+	cfg := config.LoadConfig()
+	var (
+		reply string
+		err   error
+	)
+
+	// 1.语音回复开关指令
+	if text, ok := handleSpeechCommand(requestText, h.sender.ID()); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.1.用量查询指令
+	if text, ok := handleUsageCommand(requestText, h.sender, nil); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.2.切换模型指令
+	if text, ok := handleModelCommand(requestText, h.service); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.3.角色指令
+	if text, ok := handleRoleCommand(requestText, h.sender, h.service); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.4.多会话指令
+	if text, ok := handleSessionCommand(requestText, h.service); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.5.撤销指令
+	if text, ok := handleUndoCommand(requestText, h.service); ok {
+		_, err = h.msg.ReplyText(text)
+		return err
+	}
+
+	// 1.6.重新回答指令，用上一轮的问题重新请求，回答后替换上一轮
+	if isRetryCommand(requestText) {
+		if h.retried = h.service.LastExchange(); len(h.retried) == 0 {
+			_, err = h.msg.ReplyText(noExchangeText)
+			return err
+		}
+		requestText = retryRequest(h.retried)
+	}
+
+	// 1.7.画图指令，生成图片后直接发送，不计入上下文
+	imageCmd, err := parseImageCommand(requestText)
+	if err != nil {
+		_, err = h.msg.ReplyText(err.Error())
+		return err
+	}
+	if imageCmd != nil {
+		if err = replyImages(ctx, h.msg, imageCmd); err != nil {
+			logger.Warning(fmt.Sprintf("gpt image error: %v", err))
+			if gpt.ErrorKindOf(err) == gpt.ErrorCanceled {
+				return nil
+			}
+			_, err = h.msg.ReplyText(errorReplyText(err))
+		}
+		return err
+	}
+
+	// 2.选择模型，拼接上下文向GPT发起请求，如果回复文本等于空,不回复
+	request := requestText
+	settings, requestText := selectModel(requestText, h.service)
+	if requestText == "" {
+		_, err = h.msg.ReplyText(modelQuestionEmptyText)
+		return err
+	}
+	settings = retrySettings(settings, h.retried)
+	images := h.service.GetUserImages()
+	if len(h.retried) > 0 {
+		images = h.service.ExchangeImages(h.retried[0])
+	}
+	h.questionTurn = service.NewTurn(gpt.RoleUser, requestText).WithRequest(request, settings.Model)
+	h.questionImages = images
+	question := withImages(h.questionTurn.Message(), images)
+	history := service.WithoutExchange(h.service.GetHistory(), h.retried)
+	messages := gpt.BuildPrompt(settings.Model, settings.MaxTokens, withRole(service.Messages(history), h.service), question)
+	speech := speechEnabled(h.sender.ID())
+	if useStream(cfg) && !speech {
+		return h.replyStream(ctx, settings, requestText, messages)
+	}
+	reply, usage, err := chatCompletions(ctx, settings, messages)
+	recordUsage(usage, h.sender, nil)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("gpt request error: %v", err))
+		if gpt.ErrorKindOf(err) == gpt.ErrorCanceled {
+			return nil
+		}
+		text := errorReplyText(err)
 		_, err = h.msg.ReplyText(text)
 		if err != nil {
 			return fmt.Errorf("reply user error: %v ", err)
@@ -103,9 +268,16 @@ func (h *UserMessageHandler) ReplyText() error {
 		return err
 	}
 
-	// 2.设置上下文，回复用户
-	h.service.SetUserSessionContext(requestText, reply)
-	_, err = h.msg.ReplyText(buildUserReply(reply))
+	// 2.设置上下文，回复用户，开启了语音回复的先发语音，失败时发文字
+	saveExchange(h.service, h.retried, h.questionTurn, service.NewTurn(gpt.RoleAssistant, reply), h.questionImages)
+	if speech && replySpeech(ctx, h.msg, reply) {
+		return nil
+	}
+	// 渲染成图片发送后，文字去掉markdown标记
+	if replyRendered(h.msg, reply) {
+		reply = render.PlainText(reply)
+	}
+	err = replyLongText(ctx, h.msg, reply, buildUserReply)
 	if err != nil {
 		return fmt.Errorf("reply user error: %v ", err)
 	}
@@ -114,41 +286,61 @@ func (h *UserMessageHandler) ReplyText() error {
 	return err
 }
 
+// replyStream 流式请求GPT，按段落分批回复用户，超长的段落再拆分，流结束时发送剩余内容
+func (h *UserMessageHandler) replyStream(ctx context.Context, settings gpt.ModelSettings, requestText string, messages []gpt.Message) error {
+	replier := newStreamReplier(config.LoadConfig().StreamChunkSize, func(text string, first bool) error {
+		var decorate func(string) string
+		if first {
+			decorate = buildUserReply
+		}
+		return sendParts(ctx, h.msg, splitReply(text, config.LoadConfig().ReplyMaxLength, decorationLength(decorate)), decorate)
+	})
+	reply, usage, err := gpt.ChatCompletionsStream(ctx, settings, messages, replier.Write)
+	recordUsage(usage, h.sender, nil)
+	if err != nil && !replier.Sent() {
+		logger.Warning(fmt.Sprintf("gpt request error: %v", err))
+		if gpt.ErrorKindOf(err) == gpt.ErrorCanceled {
+			return nil
+		}
+		text := errorReplyText(err)
+		_, err = h.msg.ReplyText(text)
+		if err != nil {
+			return fmt.Errorf("reply user error: %v ", err)
+		}
+		return err
+	}
+	interrupted := err != nil
+	if interrupted {
+		logger.Warning(fmt.Sprintf("gpt stream interrupted: %v", err))
+	}
+	if err = replier.Flush(); err != nil {
+		return fmt.Errorf("reply user error: %v ", err)
+	}
+	if !replier.Sent() {
+		_, err = h.msg.ReplyText(deadlineExceededText)
+		return err
+	}
+	// 文字已经逐段发出，完整结束时再补发渲染的图片
+	if !interrupted {
+		replyRendered(h.msg, reply)
+	}
+	saveExchange(h.service, h.retried, h.questionTurn, service.NewTurn(gpt.RoleAssistant, reply), h.questionImages)
+	return nil
+}
+
 // getRequestText 获取请求接口的文本，要做一些清晰
 func (h *UserMessageHandler) getRequestText() string {
 	// 1.去除空格以及换行
 	requestText := strings.TrimSpace(h.msg.Content)
-	requestText = strings.Trim(h.msg.Content, "\n")
+	requestText = strings.Trim(requestText, "\n")
 
-	// 2.获取上下文，拼接在一起，如果字符长度超出4000，截取为4000。（GPT按字符长度算），达芬奇3最大为4068，也许后续为了适应要动态进行判断。
-	sessionText := h.service.GetUserSessionContext()
-	if sessionText != "" {
-		requestText = sessionText + "\n" + requestText
-	}
-	if len(requestText) >= 4000 {
-		requestText = requestText[:4000]
-	}
-
-	// 3.检查用户发送文本是否包含结束标点符号
-	punctuation := ",.;!?，。！？、…"
-	runeRequestText := []rune(requestText)
-	lastChar := string(runeRequestText[len(runeRequestText)-1:])
-	if strings.Index(punctuation, lastChar) < 0 {
-		requestText = requestText + "？" // 判断最后字符是否加了标点，没有的话加上句号，避免openai自动补齐引起混乱。
-	}
-
-	// 4.返回请求文本
+	// 2.返回请求文本，上下文由会话按轮次单独传给GPT，超长时按token截断
 	return requestText
 }
 
 // buildUserReply 构建用户回复
 func buildUserReply(reply string) string {
-	// 1.去除空格问号以及换行号，如果为空，返回一个默认值提醒用户
-	textSplit := strings.Split(reply, "\n\n")
-	if len(textSplit) > 1 {
-		trimText := textSplit[0]
-		reply = strings.Trim(reply, trimText)
-	}
+	// 1.去除空格以及换行号，如果为空，返回一个默认值提醒用户
 	reply = strings.TrimSpace(reply)
 	if reply == "" {
 		return deadlineExceededText
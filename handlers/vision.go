@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// pictureReceivedText 私聊收到图片后的提示
+const pictureReceivedText = "收到图片，想了解图片的什么内容？"
+
+// downloadPicture 下载图片消息
+func downloadPicture(msg *openwechat.Message) ([]byte, error) {
+	return readMedia(msg.GetPicture())
+}
+
+// withImages 把会话中还没过期的图片附加到问题上
+func withImages(question gpt.Message, images [][]byte) gpt.Message {
+	detail := config.LoadConfig().VisionDetail
+	for _, image := range images {
+		question.Parts = append(question.Parts, gpt.NewImagePart(image, detail))
+	}
+	return question
+}
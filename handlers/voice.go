@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// voiceUnclearText 语音没有识别出文字时的回复
+const voiceUnclearText = "没有听清，请再说一遍或者发文字。"
+
+// errVoiceDownload 语音下载失败
+var errVoiceDownload = errors.New("download voice error")
+
+// transcribeVoice 下载语音消息并转成文字，网页版微信的语音是mp3格式
+func transcribeVoice(ctx context.Context, msg *openwechat.Message) (string, error) {
+	data, err := readMedia(msg.GetVoice())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errVoiceDownload, err)
+	}
+	ctx, cancel := withCallTimeout(ctx)
+	defer cancel()
+	return gpt.Transcribe(ctx, "voice.mp3", data)
+}
+
+// voiceErrorReplyText 语音处理出错时给用户的提示
+func voiceErrorReplyText(err error) string {
+	if errors.Is(err, errVoiceDownload) {
+		return "语音下载失败，请重新发送或者发文字。"
+	}
+	return errorReplyText(err)
+}
+
+// buildVoiceEcho 回显语音识别的内容，方便用户确认
+func buildVoiceEcho(text string) string {
+	return "[语音] " + text
+}
+
+// isVoiceWake 群聊语音无法@，识别内容包含机器人昵称或唤醒词才算叫到机器人
+func isVoiceWake(text, nickName string) bool {
+	if nickName != "" && strings.Contains(text, nickName) {
+		return true
+	}
+	for _, word := range config.LoadConfig().VoiceWakeWords {
+		if word != "" && strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// systemFonts 没有配置字体时依次查找的中文字体，Go自带的字体不包含中文
+var systemFonts = []string{
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-microhei.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-zenhei.ttc",
+	"/usr/share/fonts/wqy-microhei/wqy-microhei.ttc",
+	"/usr/share/fonts/wenquanyi/wqy-microhei/wqy-microhei.ttc",
+	"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
+	"/System/Library/Fonts/PingFang.ttc",
+	"/System/Library/Fonts/STHeiti Light.ttc",
+	"C:\\Windows\\Fonts\\msyh.ttc",
+	"C:\\Windows\\Fonts\\simhei.ttf",
+}
+
+// fontSet 按顺序排列的一组字体，每个字符使用第一个包含它的字体
+type fontSet struct {
+	fonts []*sfnt.Font
+	faces map[float64]*fallbackFace
+}
+
+// loadFonts 加载正文和代码使用的字体：正文优先使用配置的字体和系统中文字体，代码优先使用等宽字体，
+// 都以Go自带的字体兜底
+func loadFonts(file string) (text *fontSet, mono *fontSet, err error) {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse goregular error: %v", err)
+	}
+	monospace, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse gomono error: %v", err)
+	}
+
+	var cjk *sfnt.Font
+	if file != "" {
+		// 配置的字体加载失败时直接报错，避免悄悄用了别的字体
+		if cjk, err = parseFontFile(file); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for _, path := range systemFonts {
+			if cjk, err = parseFontFile(path); err == nil {
+				break
+			}
+		}
+	}
+
+	text = &fontSet{faces: map[float64]*fallbackFace{}}
+	mono = &fontSet{faces: map[float64]*fallbackFace{}}
+	if cjk != nil {
+		text.fonts = append(text.fonts, cjk)
+	}
+	text.fonts = append(text.fonts, regular)
+	mono.fonts = append(mono.fonts, monospace)
+	if cjk != nil {
+		mono.fonts = append(mono.fonts, cjk)
+	}
+	mono.fonts = append(mono.fonts, regular)
+	return text, mono, nil
+}
+
+// parseFontFile 解析ttf、otf字体文件，ttc字体集合取第一个字体
+func parseFontFile(file string) (*sfnt.Font, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(strings.ToLower(file), ".ttc") {
+		collection, err := opentype.ParseCollection(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse font %s error: %v", file, err)
+		}
+		return collection.Font(0)
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font %s error: %v", file, err)
+	}
+	return f, nil
+}
+
+// face 获取指定字号的字体，同一字号只创建一次
+func (s *fontSet) face(size float64) (*fallbackFace, error) {
+	if face, ok := s.faces[size]; ok {
+		return face, nil
+	}
+	face := &fallbackFace{fonts: s.fonts, runes: map[rune]int{}}
+	for _, f := range s.fonts {
+		ff, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, fmt.Errorf("create font face error: %v", err)
+		}
+		face.faces = append(face.faces, ff)
+	}
+	s.faces[size] = face
+	return face, nil
+}
+
+// fallbackFace 实现font.Face，每个字符交给第一个包含它的字体，都不包含时用第一个字体画出缺字方框
+type fallbackFace struct {
+	fonts  []*sfnt.Font
+	faces  []font.Face
+	buffer sfnt.Buffer
+	// 字符 -> 使用的字体下标
+	runes map[rune]int
+}
+
+// pick 选择包含该字符的字体
+func (f *fallbackFace) pick(r rune) font.Face {
+	if i, ok := f.runes[r]; ok {
+		return f.faces[i]
+	}
+	index := 0
+	for i, ff := range f.fonts {
+		if glyph, err := ff.GlyphIndex(&f.buffer, r); err == nil && glyph != 0 {
+			index = i
+			break
+		}
+	}
+	f.runes[r] = index
+	return f.faces[index]
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.pick(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.pick(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.pick(r).GlyphAdvance(r)
+}
+
+// Kern 不同字体之间不做字距调整
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.pick(r0)
+	if face != f.pick(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+// Metrics 行高等取所有字体中最大的，避免中文超出行高
+func (f *fallbackFace) Metrics() font.Metrics {
+	metrics := f.faces[0].Metrics()
+	for _, face := range f.faces[1:] {
+		m := face.Metrics()
+		if m.Ascent > metrics.Ascent {
+			metrics.Ascent = m.Ascent
+		}
+		if m.Descent > metrics.Descent {
+			metrics.Descent = m.Descent
+		}
+		if m.Height > metrics.Height {
+			metrics.Height = m.Height
+		}
+	}
+	return metrics
+}
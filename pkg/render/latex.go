@@ -0,0 +1,214 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+)
+
+// latexSymbols 常用LaTeX命令对应的Unicode字符
+var latexSymbols = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ",
+	"nu": "ν", "xi": "ξ", "pi": "π", "rho": "ρ", "sigma": "σ", "tau": "τ", "upsilon": "υ",
+	"phi": "φ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"times": "×", "cdot": "·", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "propto": "∝", "ll": "≪", "gg": "≫",
+	"infty": "∞", "sum": "∑", "prod": "∏", "int": "∫", "oint": "∮", "partial": "∂", "nabla": "∇",
+	"rightarrow": "→", "to": "→", "leftarrow": "←", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"leftrightarrow": "↔", "Leftrightarrow": "⇔", "mapsto": "↦", "implies": "⇒", "iff": "⇔",
+	"in": "∈", "notin": "∉", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"cup": "∪", "cap": "∩", "emptyset": "∅", "varnothing": "∅", "forall": "∀", "exists": "∃",
+	"neg": "¬", "land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨", "oplus": "⊕", "otimes": "⊗",
+	"ldots": "…", "cdots": "⋯", "dots": "…", "vdots": "⋮", "ddots": "⋱",
+	"circ": "∘", "degree": "°", "angle": "∠", "perp": "⊥", "parallel": "∥", "triangle": "△",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ",
+	"mathbb{R}": "ℝ", "mathbb{N}": "ℕ", "mathbb{Z}": "ℤ", "mathbb{Q}": "ℚ", "mathbb{C}": "ℂ",
+	"quad": "  ", "qquad": "    ", ",": " ", ";": " ", "!": "", " ": " ",
+	"{": "{", "}": "}", "%": "%", "$": "$", "_": "_", "&": "&", "#": "#",
+	"lbrace": "{", "rbrace": "}", "langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "|": "‖", "vert": "|", "Vert": "‖", "mid": "|",
+	"sin": "sin", "cos": "cos", "tan": "tan", "log": "log", "ln": "ln", "exp": "exp",
+	"lim": "lim", "max": "max", "min": "min", "det": "det",
+}
+
+// latexIgnored 只影响排版、转换时直接去掉的命令
+var latexIgnored = map[string]bool{
+	"left": true, "right": true, "big": true, "Big": true, "bigg": true, "Bigg": true,
+	"displaystyle": true, "limits": true, "nolimits": true,
+}
+
+// latexWrappers 只保留参数内容的命令
+var latexWrappers = map[string]bool{
+	"text": true, "mathrm": true, "mathbf": true, "mathit": true, "mathsf": true, "mathtt": true,
+	"mathcal": true, "boldsymbol": true, "operatorname": true, "textbf": true, "textit": true,
+	"vec": true, "hat": true, "bar": true, "overline": true, "tilde": true, "dot": true,
+}
+
+var superscripts = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+	'+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾', 'n': 'ⁿ', 'i': 'ⁱ', 'T': 'ᵀ',
+}
+
+var subscripts = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+	'+': '₊', '-': '₋', '=': '₌', '(': '₍', ')': '₎', 'a': 'ₐ', 'e': 'ₑ', 'i': 'ᵢ', 'j': 'ⱼ',
+	'k': 'ₖ', 'n': 'ₙ', 'm': 'ₘ', 'x': 'ₓ',
+}
+
+// LatexToText 把LaTeX公式转成可读的Unicode文本，如 \frac{a}{b} 转成 a/b，x^{2} 转成 x²
+func LatexToText(latex string) string {
+	p := &latexParser{src: []rune(latex)}
+	text := p.parse(false)
+	// 对齐符号和换行只保留大致格式
+	text = strings.ReplaceAll(text, "&", " ")
+	return strings.TrimSpace(text)
+}
+
+// latexParser 简单的递归下降转换，不认识的命令原样保留
+type latexParser struct {
+	src []rune
+	pos int
+}
+
+// parse 转换到字符串末尾，inGroup为true时遇到 } 结束
+func (p *latexParser) parse(inGroup bool) string {
+	var out strings.Builder
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		switch {
+		case r == '}' && inGroup:
+			p.pos++
+			return out.String()
+		case r == '{':
+			p.pos++
+			out.WriteString(p.parse(true))
+		case r == '\\':
+			out.WriteString(p.command())
+		case r == '^' || r == '_':
+			p.pos++
+			out.WriteString(script(p.argument(), r == '^'))
+		case r == '~':
+			p.pos++
+			out.WriteRune(' ')
+		default:
+			p.pos++
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// command 转换 \ 开头的命令
+func (p *latexParser) command() string {
+	p.pos++
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	// 单个符号的命令，如 \, \{ \\
+	if !unicode.IsLetter(p.src[p.pos]) {
+		r := p.src[p.pos]
+		p.pos++
+		if r == '\\' {
+			return "\n"
+		}
+		return latexSymbols[string(r)]
+	}
+	start := p.pos
+	for p.pos < len(p.src) && unicode.IsLetter(p.src[p.pos]) {
+		p.pos++
+	}
+	name := string(p.src[start:p.pos])
+
+	switch {
+	case name == "frac" || name == "dfrac" || name == "tfrac":
+		return fraction(p.argument(), p.argument())
+	case name == "sqrt":
+		index := ""
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			end := p.pos
+			for end < len(p.src) && p.src[end] != ']' {
+				end++
+			}
+			index = string(p.src[p.pos+1 : end])
+			p.pos = end + 1
+		}
+		return script(index, true) + "√" + group(p.argument())
+	case name == "mathbb":
+		arg := p.argument()
+		if symbol, ok := latexSymbols["mathbb{"+arg+"}"]; ok {
+			return symbol
+		}
+		return arg
+	case latexWrappers[name]:
+		return p.argument()
+	case latexIgnored[name]:
+		return ""
+	case name == "begin" || name == "end":
+		// 环境名不输出，如 \begin{aligned}
+		p.argument()
+		return ""
+	}
+	if symbol, ok := latexSymbols[name]; ok {
+		return symbol
+	}
+	return "\\" + name
+}
+
+// argument 读取命令的一个参数：{...} 或者单个字符/命令
+func (p *latexParser) argument() string {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	switch p.src[p.pos] {
+	case '{':
+		p.pos++
+		return p.parse(true)
+	case '\\':
+		return p.command()
+	default:
+		r := p.src[p.pos]
+		p.pos++
+		return string(r)
+	}
+}
+
+// fraction 分数，分子或分母不止一个字符时加括号
+func fraction(numerator, denominator string) string {
+	return group(numerator) + "/" + group(denominator)
+}
+
+// group 不止一个字符时加括号
+func group(text string) string {
+	if len([]rune(text)) <= 1 {
+		return text
+	}
+	return "(" + text + ")"
+}
+
+// script 上标或下标，所有字符都有对应的Unicode上下标时直接转换，否则用 ^() _() 表示
+func script(text string, super bool) string {
+	if text == "" {
+		return ""
+	}
+	table := subscripts
+	mark := "_"
+	if super {
+		table = superscripts
+		mark = "^"
+	}
+	var out strings.Builder
+	for _, r := range text {
+		converted, ok := table[r]
+		if !ok {
+			return mark + group(text)
+		}
+		out.WriteRune(converted)
+	}
+	return out.String()
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+)
+
+// blockKind markdown块的类型
+type blockKind int
+
+const (
+	blockText blockKind = iota
+	blockHeading
+	blockCode
+	blockTable
+	blockMath
+	blockQuote
+	blockList
+	blockRule
+	blockBlank
+)
+
+// block 解析后的一个markdown块
+type block struct {
+	kind blockKind
+	// 文本、标题、引用、列表项的内容，公式转换后的文本
+	text string
+	// 标题级别，列表缩进层级
+	level int
+	// 代码块的语言
+	language string
+	// 代码块的行
+	lines []string
+	// 表格的行，第一行为表头
+	rows [][]string
+}
+
+var (
+	headingPattern      = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	listPattern         = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	rulePattern         = regexp.MustCompile(`^(-\s*){3,}$|^(\*\s*){3,}$|^(_\s*){3,}$`)
+	tableDividerPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	linkPattern         = regexp.MustCompile(`!?\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	// 先匹配 $$...$$，再匹配 $...$，公式两边紧挨着$的不能是空白，避免把 $5 和 $10 当成公式
+	inlineMathPattern = regexp.MustCompile(`\$\$([^$\n]+?)\$\$|\$([^\s$]|[^\s$][^$\n]*?[^\s$])\$|\\\((.+?)\\\)`)
+	emphasisPattern   = regexp.MustCompile(`(\*\*|__|~~)(.+?)(\*\*|__|~~)`)
+)
+
+// parseMarkdown 把markdown拆成块，只支持聊天回复中常见的语法
+func parseMarkdown(markdown string) []block {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	var blocks []block
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			blocks = append(blocks, block{kind: blockBlank})
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			// 1.代码块，没有结束标记时到回复末尾为止
+			fence := trimmed[:3]
+			code := block{kind: blockCode, language: strings.TrimSpace(trimmed[3:])}
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					break
+				}
+				code.lines = append(code.lines, strings.TrimRight(lines[i], " \t"))
+			}
+			blocks = append(blocks, code)
+		case trimmed == "$$" || trimmed == `\[` || (strings.HasPrefix(trimmed, "$$") && strings.HasSuffix(trimmed, "$$") && len(trimmed) > 4) ||
+			(strings.HasPrefix(trimmed, `\[`) && strings.HasSuffix(trimmed, `\]`)):
+			// 2.公式块，支持 $$...$$ 和 \[...\]，可以写在一行或多行
+			end := "$$"
+			if strings.HasPrefix(trimmed, `\[`) {
+				end = `\]`
+			}
+			body := strings.TrimSpace(trimmed[2:])
+			for !strings.HasSuffix(body, end) && i+1 < len(lines) {
+				i++
+				body += "\n" + strings.TrimSpace(lines[i])
+			}
+			body = strings.TrimSuffix(body, end)
+			for _, formula := range strings.Split(body, "\n") {
+				if formula = LatexToText(formula); formula != "" {
+					blocks = append(blocks, block{kind: blockMath, text: formula})
+				}
+			}
+		case isTableRow(trimmed) && i+1 < len(lines) && tableDividerPattern.MatchString(lines[i+1]):
+			// 3.表格，表头下一行是分隔行
+			table := block{kind: blockTable, rows: [][]string{splitTableRow(trimmed)}}
+			for i += 2; i < len(lines) && isTableRow(strings.TrimSpace(lines[i])); i++ {
+				table.rows = append(table.rows, splitTableRow(strings.TrimSpace(lines[i])))
+			}
+			i--
+			blocks = append(blocks, table)
+		case rulePattern.MatchString(trimmed):
+			blocks = append(blocks, block{kind: blockRule})
+		case headingPattern.MatchString(trimmed):
+			match := headingPattern.FindStringSubmatch(trimmed)
+			blocks = append(blocks, block{kind: blockHeading, level: len(match[1]), text: inlineText(match[2])})
+		case strings.HasPrefix(trimmed, ">"):
+			blocks = append(blocks, block{kind: blockQuote, text: inlineText(strings.TrimSpace(strings.TrimLeft(trimmed, ">")))})
+		case listPattern.MatchString(line):
+			// 4.列表项，无序列表统一用圆点，有序列表保留序号
+			match := listPattern.FindStringSubmatch(line)
+			marker := match[2]
+			if strings.ContainsAny(marker, "-*+") {
+				marker = "•"
+			}
+			level := len(strings.ReplaceAll(match[1], "\t", "  ")) / 2
+			blocks = append(blocks, block{kind: blockList, level: level, text: marker + " " + inlineText(match[3])})
+		default:
+			blocks = append(blocks, block{kind: blockText, text: inlineText(trimmed)})
+		}
+	}
+	return blocks
+}
+
+// isTableRow 是否为表格行，如 | a | b |
+func isTableRow(line string) bool {
+	return strings.Contains(line, "|")
+}
+
+// splitTableRow 拆分表格行的单元格
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = inlineText(strings.TrimSpace(cells[i]))
+	}
+	return cells
+}
+
+// inlineText 去掉行内的markdown标记：加粗、删除线、行内代码的反引号，链接转成 文字(地址)，行内公式转成Unicode文本
+func inlineText(text string) string {
+	text = inlineMathPattern.ReplaceAllStringFunc(text, func(match string) string {
+		sub := inlineMathPattern.FindStringSubmatch(match)
+		for _, formula := range sub[1:] {
+			if formula != "" {
+				return LatexToText(formula)
+			}
+		}
+		return match
+	})
+	text = linkPattern.ReplaceAllStringFunc(text, func(match string) string {
+		sub := linkPattern.FindStringSubmatch(match)
+		if sub[1] == "" || sub[1] == sub[2] {
+			return sub[2]
+		}
+		return sub[1] + " (" + sub[2] + ")"
+	})
+	text = emphasisPattern.ReplaceAllString(text, "$2")
+	return strings.ReplaceAll(text, "`", "")
+}
@@ -0,0 +1,520 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultWidth    = 900
+	defaultFontSize = 20
+	// maxHeight 图片最大高度，太长的回复直接发文字
+	maxHeight = 16000
+	// padding 图片四周留白
+	padding = 24
+	// cellPadding 表格单元格内边距
+	cellPadding = 10
+	// codePadding 代码块内边距
+	codePadding = 14
+	// codeStyle 代码高亮的配色
+	codeStyle = "github"
+)
+
+var (
+	backgroundColor = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	textColor       = color.RGBA{R: 0x24, G: 0x29, B: 0x2f, A: 0xff}
+	quoteColor      = color.RGBA{R: 0x57, G: 0x60, B: 0x6a, A: 0xff}
+	borderColor     = color.RGBA{R: 0xd0, G: 0xd7, B: 0xde, A: 0xff}
+	codeBackground  = color.RGBA{R: 0xf6, G: 0xf8, B: 0xfa, A: 0xff}
+	mathBackground  = color.RGBA{R: 0xf5, G: 0xf3, B: 0xff, A: 0xff}
+)
+
+// ErrTooLarge 回复太长，渲染出的图片超过最大高度
+var ErrTooLarge = errors.New("render image too large")
+
+// Options 渲染参数，为0或为空时使用默认值
+type Options struct {
+	// 图片宽度，像素
+	Width int
+	// 字体文件，支持ttf、otf、ttc，为空时查找系统中文字体
+	FontFile string
+	// 正文字号，像素
+	FontSize float64
+}
+
+var (
+	// 字体对象不能并发使用，渲染时加锁
+	lock      sync.Mutex
+	fontCache = map[string][2]*fontSet{}
+)
+
+// Render 把markdown回复渲染成PNG图片：代码块使用等宽字体并按语言高亮，表格画出表格线，公式转成Unicode文本
+func Render(markdown string, options Options) ([]byte, error) {
+	if options.Width <= 0 {
+		options.Width = defaultWidth
+	}
+	if options.FontSize <= 0 {
+		options.FontSize = defaultFontSize
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// 1.加载字体，同一个字体文件只加载一次
+	fonts, ok := fontCache[options.FontFile]
+	if !ok {
+		text, mono, err := loadFonts(options.FontFile)
+		if err != nil {
+			return nil, err
+		}
+		fonts = [2]*fontSet{text, mono}
+		fontCache[options.FontFile] = fonts
+	}
+	r := &renderer{width: options.Width, size: options.FontSize, text: fonts[0], mono: fonts[1]}
+
+	// 2.排版，计算每一块的高度
+	for _, b := range parseMarkdown(markdown) {
+		if err := r.layout(b); err != nil {
+			return nil, err
+		}
+	}
+	height := padding * 2
+	for _, item := range r.items {
+		height += item.height
+	}
+	if height > maxHeight {
+		return nil, ErrTooLarge
+	}
+
+	// 3.依次绘制，编码成PNG
+	img := image.NewRGBA(image.Rect(0, 0, options.Width, height))
+	fill(img, img.Bounds(), backgroundColor)
+	top := padding
+	for _, item := range r.items {
+		if item.draw != nil {
+			item.draw(img, top)
+		}
+		top += item.height
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// item 排版后的一块，draw为空时只占位
+type item struct {
+	height int
+	draw   func(dst *image.RGBA, top int)
+}
+
+// renderer 一次渲染的排版状态
+type renderer struct {
+	width int
+	size  float64
+	text  *fontSet
+	mono  *fontSet
+	items []item
+	// 上一块是否为空行，连续的空行只保留一个
+	blank bool
+}
+
+// contentWidth 去掉留白后的可用宽度
+func (r *renderer) contentWidth() int {
+	return r.width - padding*2
+}
+
+// add 添加一块
+func (r *renderer) add(height int, draw func(dst *image.RGBA, top int)) {
+	r.items = append(r.items, item{height: height, draw: draw})
+	r.blank = false
+}
+
+// gap 添加空白
+func (r *renderer) gap(height int) {
+	r.items = append(r.items, item{height: height})
+}
+
+// layout 排版一个markdown块
+func (r *renderer) layout(b block) error {
+	switch b.kind {
+	case blockBlank:
+		if !r.blank && len(r.items) > 0 {
+			r.gap(int(r.size / 2))
+			r.blank = true
+		}
+		return nil
+	case blockCode:
+		return r.layoutCode(b)
+	case blockTable:
+		return r.layoutTable(b)
+	}
+
+	face, err := r.text.face(r.size)
+	if err != nil {
+		return err
+	}
+	switch b.kind {
+	case blockHeading:
+		scale := []float64{1.6, 1.4, 1.25, 1.1, 1, 1}[b.level-1]
+		if face, err = r.text.face(math.Round(r.size * scale)); err != nil {
+			return err
+		}
+		r.layoutText(face, b.text, 0, 0, textColor, true)
+	case blockQuote:
+		start := len(r.items)
+		r.layoutText(face, b.text, 16, 0, quoteColor, false)
+		// 引用左侧的竖线
+		for i := start; i < len(r.items); i++ {
+			drawLine := r.items[i].draw
+			height := r.items[i].height
+			r.items[i].draw = func(dst *image.RGBA, top int) {
+				fill(dst, image.Rect(padding, top, padding+4, top+height), borderColor)
+				drawLine(dst, top)
+			}
+		}
+	case blockList:
+		indent := b.level * 24
+		marker := strings.SplitN(b.text, " ", 2)[0] + " "
+		r.layoutText(face, b.text, indent, measure(face, marker), textColor, false)
+	case blockMath:
+		if face, err = r.text.face(math.Round(r.size * 1.15)); err != nil {
+			return err
+		}
+		r.layoutMath(face, b.text)
+	case blockRule:
+		r.add(int(r.size), func(dst *image.RGBA, top int) {
+			y := top + int(r.size)/2
+			fill(dst, image.Rect(padding, y, r.width-padding, y+2), borderColor)
+		})
+	default:
+		r.layoutText(face, b.text, 0, 0, textColor, false)
+	}
+	return nil
+}
+
+// layoutText 按宽度折行排版一段文字，indent为整段缩进，hanging为第二行起的额外缩进（列表序号后对齐）
+func (r *renderer) layoutText(face font.Face, text string, indent, hanging int, c color.Color, bold bool) {
+	lineHeight := lineHeightOf(face, 1.6)
+	for i, line := range wrap(face, text, r.contentWidth()-indent-hanging) {
+		x := padding + indent
+		if i > 0 {
+			x += hanging
+		}
+		line := line
+		r.add(lineHeight, func(dst *image.RGBA, top int) {
+			drawString(dst, face, x, baselineOf(face, top, lineHeight), c, line, bold)
+		})
+	}
+}
+
+// layoutMath 公式居中显示在浅色背景上
+func (r *renderer) layoutMath(face font.Face, text string) {
+	lineHeight := lineHeightOf(face, 1.8)
+	lines := wrap(face, text, r.contentWidth()-codePadding*2)
+	r.gap(4)
+	r.add(lineHeight*len(lines), func(dst *image.RGBA, top int) {
+		fill(dst, image.Rect(padding, top, r.width-padding, top+lineHeight*len(lines)), mathBackground)
+		for i, line := range lines {
+			x := (r.width - measure(face, line)) / 2
+			drawString(dst, face, x, baselineOf(face, top+i*lineHeight, lineHeight), textColor, line, false)
+		}
+	})
+	r.gap(4)
+}
+
+// segment 代码中颜色相同的一段
+type segment struct {
+	text  string
+	color color.Color
+}
+
+// layoutCode 代码块使用等宽字体按语言高亮，超长的行按字符折行
+func (r *renderer) layoutCode(b block) error {
+	face, err := r.mono.face(math.Round(r.size * 0.9))
+	if err != nil {
+		return err
+	}
+	lineHeight := lineHeightOf(face, 1.5)
+	width := fixed.I(r.contentWidth() - codePadding*2)
+
+	// 1.按宽度折行，保留每个字符的颜色
+	var rows [][]segment
+	for _, line := range highlight(b.lines, b.language) {
+		var row []segment
+		advance := fixed.I(0)
+		for _, seg := range line {
+			for _, char := range seg.text {
+				a, _ := face.GlyphAdvance(char)
+				if advance+a > width && advance > 0 {
+					rows = append(rows, row)
+					row, advance = nil, 0
+				}
+				row = append(row, segment{text: string(char), color: seg.color})
+				advance += a
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		rows = [][]segment{nil}
+	}
+
+	// 2.背景和代码
+	height := lineHeight*len(rows) + codePadding*2
+	r.gap(6)
+	r.add(height, func(dst *image.RGBA, top int) {
+		fill(dst, image.Rect(padding, top, r.width-padding, top+height), codeBackground)
+		for i, row := range rows {
+			baseline := baselineOf(face, top+codePadding+i*lineHeight, lineHeight)
+			drawer := &font.Drawer{Dst: dst, Face: face, Dot: fixed.P(padding+codePadding, baseline)}
+			for _, seg := range row {
+				drawer.Src = image.NewUniform(seg.color)
+				drawer.DrawString(seg.text)
+			}
+		}
+	})
+	r.gap(6)
+	return nil
+}
+
+// highlight 按语言给代码着色，没有指定语言时自动识别
+func highlight(lines []string, language string) [][]segment {
+	for i := range lines {
+		lines[i] = strings.ReplaceAll(lines[i], "\t", "    ")
+	}
+	plain := make([][]segment, len(lines))
+	for i, line := range lines {
+		plain[i] = []segment{{text: line, color: textColor}}
+	}
+
+	code := strings.Join(lines, "\n")
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		return plain
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return plain
+	}
+	style := styles.Get(codeStyle)
+	highlighted := make([][]segment, len(lines))
+	for i, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		if i >= len(lines) {
+			break
+		}
+		for _, token := range tokens {
+			c := color.Color(textColor)
+			if entry := style.Get(token.Type); entry.Colour.IsSet() {
+				c = color.RGBA{R: entry.Colour.Red(), G: entry.Colour.Green(), B: entry.Colour.Blue(), A: 0xff}
+			}
+			highlighted[i] = append(highlighted[i], segment{text: strings.TrimRight(token.Value, "\n"), color: c})
+		}
+	}
+	return highlighted
+}
+
+// layoutTable 表格列宽按内容分配，放不下时较宽的列平分剩余宽度，单元格内容折行显示
+func (r *renderer) layoutTable(b block) error {
+	face, err := r.text.face(math.Round(r.size * 0.9))
+	if err != nil {
+		return err
+	}
+	lineHeight := lineHeightOf(face, 1.5)
+
+	// 1.列宽
+	columns := 0
+	for _, row := range b.rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	natural := make([]int, columns)
+	for _, row := range b.rows {
+		for j, cell := range row {
+			if w := measure(face, cell) + cellPadding*2 + 2; w > natural[j] {
+				natural[j] = w
+			}
+		}
+	}
+	widths := columnWidths(natural, r.contentWidth())
+
+	// 2.每行的高度，单元格折行
+	cells := make([][][]string, len(b.rows))
+	heights := make([]int, len(b.rows))
+	total := 0
+	for i, row := range b.rows {
+		cells[i] = make([][]string, columns)
+		lines := 1
+		for j := 0; j < columns; j++ {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			cells[i][j] = wrap(face, cell, widths[j]-cellPadding*2)
+			if len(cells[i][j]) > lines {
+				lines = len(cells[i][j])
+			}
+		}
+		heights[i] = lines*lineHeight + cellPadding
+		total += heights[i]
+	}
+	tableWidth := 0
+	for _, w := range widths {
+		tableWidth += w
+	}
+
+	// 3.表头背景、单元格内容和表格线
+	r.gap(6)
+	r.add(total+1, func(dst *image.RGBA, top int) {
+		fill(dst, image.Rect(padding, top, padding+tableWidth, top+heights[0]), codeBackground)
+		y := top
+		for i := range cells {
+			x := padding
+			for j, lines := range cells[i] {
+				for k, line := range lines {
+					baseline := baselineOf(face, y+cellPadding/2+k*lineHeight, lineHeight)
+					drawString(dst, face, x+cellPadding, baseline, textColor, line, i == 0)
+				}
+				x += widths[j]
+			}
+			fill(dst, image.Rect(padding, y, padding+tableWidth, y+1), borderColor)
+			y += heights[i]
+		}
+		fill(dst, image.Rect(padding, y, padding+tableWidth+1, y+1), borderColor)
+		x := padding
+		for _, w := range widths {
+			fill(dst, image.Rect(x, top, x+1, y), borderColor)
+			x += w
+		}
+		fill(dst, image.Rect(x, top, x+1, y), borderColor)
+	})
+	r.gap(6)
+	return nil
+}
+
+// columnWidths 总宽度放得下时按内容宽度，放不下时从窄到宽依次分配，每列最多分到剩余宽度的平均值
+func columnWidths(natural []int, available int) []int {
+	sum := 0
+	for _, w := range natural {
+		sum += w
+	}
+	widths := make([]int, len(natural))
+	if sum <= available {
+		copy(widths, natural)
+		return widths
+	}
+	order := make([]int, len(natural))
+	for i := range order {
+		order[i] = i
+	}
+	for i := 1; i < len(order); i++ {
+		for j := i; j > 0 && natural[order[j]] < natural[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+	remaining := available
+	for i, index := range order {
+		share := remaining / (len(order) - i)
+		w := natural[index]
+		if w > share {
+			w = share
+		}
+		widths[index] = w
+		remaining -= w
+	}
+	return widths
+}
+
+// wrap 按宽度折行：中日韩文字可以在任意字符处断开，英文尽量在空格处断开，单词太长时按字符断开
+func wrap(face font.Face, text string, width int) []string {
+	runes := []rune(text)
+	limit := fixed.I(width)
+	var lines []string
+	start, lastSpace := 0, -1
+	advance := fixed.I(0)
+	prev := rune(-1)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		if prev >= 0 {
+			advance += face.Kern(prev, char)
+		}
+		a, _ := face.GlyphAdvance(char)
+		if advance+a > limit && i > start {
+			end := i
+			if !isWide(char) && char != ' ' && lastSpace > start {
+				end = lastSpace + 1
+			}
+			lines = append(lines, strings.TrimRight(string(runes[start:end]), " "))
+			for start = end; start < len(runes) && runes[start] == ' '; start++ {
+			}
+			i, lastSpace, advance, prev = start-1, -1, 0, -1
+			continue
+		}
+		advance += a
+		prev = char
+		if char == ' ' || isWide(char) {
+			lastSpace = i
+		}
+	}
+	if start < len(runes) || len(lines) == 0 {
+		lines = append(lines, string(runes[start:]))
+	}
+	return lines
+}
+
+// isWide 是否为可以在任意位置断行的中日韩文字或全角符号
+func isWide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef)
+}
+
+// measure 文字宽度，像素
+func measure(face font.Face, text string) int {
+	return font.MeasureString(face, text).Ceil()
+}
+
+// lineHeightOf 行高为字体高度乘以行距
+func lineHeightOf(face font.Face, spacing float64) int {
+	m := face.Metrics()
+	return int(math.Ceil(float64(m.Ascent+m.Descent) / 64 * spacing))
+}
+
+// baselineOf 文字在行内垂直居中时的基线位置
+func baselineOf(face font.Face, top, lineHeight int) int {
+	m := face.Metrics()
+	return top + (lineHeight-(m.Ascent+m.Descent).Ceil())/2 + m.Ascent.Ceil()
+}
+
+// drawString 绘制一行文字，bold为true时错开一个像素再画一遍模拟加粗
+func drawString(dst *image.RGBA, face font.Face, x, baseline int, c color.Color, text string, bold bool) {
+	drawer := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, baseline)}
+	drawer.DrawString(text)
+	if bold {
+		drawer.Dot = fixed.P(x+1, baseline)
+		drawer.DrawString(text)
+	}
+}
+
+// fill 填充矩形
+func fill(dst *image.RGBA, rect image.Rectangle, c color.Color) {
+	draw.Draw(dst, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
@@ -0,0 +1,53 @@
+package render
+
+import (
+	"strings"
+)
+
+// NeedsRender 回复中是否有代码块、表格或公式，纯文字的回复直接发文字就够了
+func NeedsRender(markdown string) bool {
+	for _, b := range parseMarkdown(markdown) {
+		switch b.kind {
+		case blockCode, blockTable, blockMath:
+			return true
+		}
+	}
+	// 行内公式，只有 $5 这样的数字不算
+	for _, match := range inlineMathPattern.FindAllStringSubmatch(markdown, -1) {
+		if strings.ContainsAny(match[0], `\^_`) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlainText 去掉markdown标记后的纯文本：代码块去掉```标记，表格每行用 | 分隔，公式转成Unicode文本，
+// 连续的空行只保留一个
+func PlainText(markdown string) string {
+	var lines []string
+	blank := false
+	for _, b := range parseMarkdown(markdown) {
+		// 分隔线按空行处理
+		if b.kind == blockBlank || b.kind == blockRule {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		switch b.kind {
+		case blockCode:
+			lines = append(lines, b.lines...)
+		case blockTable:
+			for _, row := range b.rows {
+				lines = append(lines, strings.Join(row, " | "))
+			}
+		case blockList:
+			lines = append(lines, strings.Repeat("  ", b.level)+b.text)
+		default:
+			lines = append(lines, b.text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,46 @@
+package render
+
+import "testing"
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"**加粗** 和 `代码`", "加粗 和 代码"},
+		{"[文档](https://example.com)", "文档 (https://example.com)"},
+		{"价格是 $5 到 $10", "价格是 $5 到 $10"},
+		{"面积是 $a^2$ 平方米", "面积是 a² 平方米"},
+		{"面积是 $$a^2$$ 平方米", "面积是 a² 平方米"},
+		{"$$a^2$$ 和 $b_1$", "a² 和 b₁"},
+		{"公式 \\(x^2\\)", "公式 x²"},
+		{"```go\nfmt.Println(1)\n```", "fmt.Println(1)"},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", "a | b\n1 | 2"},
+		{"第一段\n\n\n\n第二段", "第一段\n\n第二段"},
+		{"- 一\n  - 二", "• 一\n  • 二"},
+	}
+	for _, tt := range tests {
+		if got := PlainText(tt.markdown); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsRender(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     bool
+	}{
+		{"普通的回答", false},
+		{"价格是 $5 到 $10", false},
+		{"面积是 $a^2$", true},
+		{"面积是 $$a^2$$", true},
+		{"```\ncode\n```", true},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", true},
+	}
+	for _, tt := range tests {
+		if got := NeedsRender(tt.markdown); got != tt.want {
+			t.Errorf("NeedsRender(%q) = %v, want %v", tt.markdown, got, tt.want)
+		}
+	}
+}
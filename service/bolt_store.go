@@ -0,0 +1,130 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket 会话数据所在的bucket
+var boltBucket = []byte("sessions")
+
+// BoltStore 基于bbolt的本地文件存储，每条数据前8个字节为过期时间（UnixNano，0表示不过期），
+// 读取时发现过期直接当作不存在，后台定期删除过期数据
+type BoltStore struct {
+	db   *bolt.DB
+	done chan struct{}
+}
+
+var _ Store = (*BoltStore)(nil)
+
+// NewBoltStore 打开或创建存储文件，文件被其他进程占用时等待1秒后报错
+func NewBoltStore(file string) (*BoltStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open session file %s error: %v", file, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create session bucket error: %v", err)
+	}
+	s := &BoltStore{db: db, done: make(chan struct{})}
+	go s.cleanup()
+	return s, nil
+}
+
+func (s *BoltStore) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var expired bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		if len(data) < 8 || isExpired(data) {
+			expired = true
+			return nil
+		}
+		// bbolt返回的数据只在事务内有效
+		value = append([]byte(nil), data[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if expired {
+		return nil, false, s.Delete(key)
+	}
+	return value, value != nil, nil
+}
+
+func (s *BoltStore) Set(key string, value []byte, ttl time.Duration) error {
+	data := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(data[8:], value)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	close(s.done)
+	return s.db.Close()
+}
+
+// cleanup 定期删除过期数据，避免文件一直变大
+func (s *BoltStore) cleanup() {
+	ticker := time.NewTicker(storeCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			// 遍历时删除会跳过下一条，先找出过期的key再删除
+			bucket := tx.Bucket(boltBucket)
+			var expired [][]byte
+			err := bucket.ForEach(func(key, data []byte) error {
+				if len(data) < 8 || isExpired(data) {
+					expired = append(expired, append([]byte(nil), key...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range expired {
+				if err = bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Warning(fmt.Sprintf("cleanup session file error: %v", err))
+		}
+	}
+}
+
+// isExpired 数据是否已过期
+func isExpired(data []byte) bool {
+	expires := binary.BigEndian.Uint64(data)
+	return expires != 0 && time.Now().UnixNano() > int64(expires)
+}
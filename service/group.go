@@ -0,0 +1,38 @@
+package service
+
+import (
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// groupImageStore 群成员发的图片，不一定是给机器人的，只放在内存中，不写入会话存储
+var groupImageStore Store = NewMemoryStore()
+
+// sharedSessionKey 群共享上下文的会话历史key
+func sharedSessionKey(group *openwechat.Group) string {
+	return "group:" + group.ID()
+}
+
+// sharedSettingKey 群共享上下文开关的key
+func sharedSettingKey(group *openwechat.Group) string {
+	return sharedSessionKey(group) + ":shared"
+}
+
+// SharedContextEnabled 群是否使用共享上下文，在群里用指令设置过的以设置为准，否则看配置的群昵称，"*"表示所有群
+func SharedContextEnabled(store Store, group *openwechat.Group) bool {
+	var enabled bool
+	if getJSON(store, sharedSettingKey(group), &enabled) {
+		return enabled
+	}
+	for _, name := range config.LoadConfig().SharedContextGroups {
+		if name == "*" || name == group.NickName {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSharedContext 设置群是否使用共享上下文，不过期
+func SetSharedContext(store Store, group *openwechat.Group, enabled bool) {
+	setJSON(store, sharedSettingKey(group), enabled, 0)
+}
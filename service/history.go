@@ -0,0 +1,121 @@
+package service
+
+import (
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// Turn 会话历史中的一条消息
+type Turn struct {
+	// 角色，user、assistant或system
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// 发言人昵称，群共享上下文时记录是谁说的
+	Name string `json:"name,omitempty"`
+	// 消息时间
+	Timestamp time.Time `json:"timestamp"`
+	// 按默认模型估算的token数，裁剪历史时使用，不用每次重新计算
+	Tokens int `json:"tokens"`
+	// 是否为较早对话压缩成的摘要
+	Summary bool `json:"summary,omitempty"`
+	// 提问的原始文本（含单次指定模型的指令）和使用的模型，重新回答时使用，带的图片只放在内存中，见SetExchangeImages
+	Request string `json:"request,omitempty"`
+	Model   string `json:"model,omitempty"`
+}
+
+// NewTurn 创建一条当前时间的消息，并计算token数
+func NewTurn(role, content string) Turn {
+	return newTurn(role, "", content)
+}
+
+// WithRequest 记下提问的原始文本和使用的模型，重新回答时按原样再问一次
+func (t Turn) WithRequest(request, model string) Turn {
+	t.Request, t.Model = request, model
+	return t
+}
+
+// NewSpeakerTurn 创建一条带发言人的用户消息，群共享上下文时使用
+func NewSpeakerTurn(name, content string) Turn {
+	return newTurn(gpt.RoleUser, name, content)
+}
+
+func newTurn(role, name, content string) Turn {
+	turn := Turn{Role: role, Name: name, Content: content, Timestamp: time.Now()}
+	turn.Tokens = gpt.CountMessageTokens(config.LoadConfig().Model, turn.Message())
+	return turn
+}
+
+// Message 转成请求接口的消息，有发言人时内容前加上昵称，接口的name字段不支持中文昵称
+func (t Turn) Message() gpt.Message {
+	if t.Name != "" {
+		return gpt.Message{Role: t.Role, Content: t.Name + "：" + t.Content}
+	}
+	return gpt.Message{Role: t.Role, Content: t.Content}
+}
+
+// Messages 把会话历史转成请求接口的消息
+func Messages(turns []Turn) []gpt.Message {
+	messages := make([]gpt.Message, 0, len(turns))
+	for _, turn := range turns {
+		messages = append(messages, turn.Message())
+	}
+	return messages
+}
+
+// TrimTurns 从最新的消息往前保留，使token总数不超过budget，保留部分不以assistant回复开头，
+// 开头的system消息不计入裁剪，始终保留
+func TrimTurns(turns []Turn, budget int) []Turn {
+	var pinned []Turn
+	for len(turns) > 0 && turns[0].Role == gpt.RoleSystem {
+		pinned = append(pinned, turns[0])
+		budget -= turns[0].Tokens
+		turns = turns[1:]
+	}
+
+	start := len(turns)
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		if used+turns[i].Tokens > budget {
+			break
+		}
+		used += turns[i].Tokens
+		start = i
+	}
+	for start < len(turns) && turns[start].Role == gpt.RoleAssistant {
+		start++
+	}
+	if len(pinned) == 0 {
+		return turns[start:]
+	}
+	return append(pinned, turns[start:]...)
+}
+
+// lastExchange 最后一轮问答的位置，从最后一条回复往前找到它的提问，没有时ok为false
+func lastExchange(turns []Turn) (question, answer int, ok bool) {
+	for answer = len(turns) - 1; answer >= 0; answer-- {
+		if turns[answer].Role == gpt.RoleAssistant {
+			break
+		}
+	}
+	for question = answer - 1; question >= 0; question-- {
+		if turns[question].Role == gpt.RoleUser {
+			return question, answer, true
+		}
+	}
+	return 0, 0, false
+}
+
+// WithoutExchange 去掉会话历史中的exchange这一轮问答，exchange为空（不是重新回答）或者它已经不是最后一轮
+// （被撤销、替换或压缩）时原样返回
+func WithoutExchange(turns, exchange []Turn) []Turn {
+	if len(exchange) != 2 {
+		return turns
+	}
+	question, answer, ok := lastExchange(turns)
+	if !ok || !sameTurns([]Turn{turns[question], turns[answer]}, exchange) {
+		return turns
+	}
+	return append(turns[:question:question], turns[answer+1:]...)
+}
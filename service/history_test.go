@@ -0,0 +1,80 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// testTurns 按角色缩写创建消息：u提问，a回复，s system，内容为序号，时间依次递增
+func testTurns(roles string) []Turn {
+	names := map[rune]string{'u': gpt.RoleUser, 'a': gpt.RoleAssistant, 's': gpt.RoleSystem}
+	start := time.Unix(0, 0)
+	turns := make([]Turn, 0, len(roles))
+	for i, r := range roles {
+		turns = append(turns, Turn{Role: names[r], Content: string(rune('0' + i)), Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	return turns
+}
+
+// contents 消息内容拼在一起，方便比较
+func contents(turns []Turn) string {
+	var s string
+	for _, turn := range turns {
+		s += turn.Content
+	}
+	return s
+}
+
+func TestLastExchange(t *testing.T) {
+	tests := []struct {
+		roles    string
+		question int
+		answer   int
+		ok       bool
+	}{
+		{"", 0, 0, false},
+		{"u", 0, 0, false},
+		{"a", 0, 0, false},
+		{"ua", 0, 1, true},
+		{"uaua", 2, 3, true},
+		// 共享上下文的群里，回复之后还有没@机器人的发言
+		{"uauau", 2, 3, true},
+		// 同一轮中回复之前还有别人的发言
+		{"uuau", 1, 2, true},
+		{"sua", 1, 2, true},
+	}
+	for _, tt := range tests {
+		question, answer, ok := lastExchange(testTurns(tt.roles))
+		if question != tt.question || answer != tt.answer || ok != tt.ok {
+			t.Errorf("lastExchange(%s) = %d, %d, %v, want %d, %d, %v", tt.roles, question, answer, ok, tt.question, tt.answer, tt.ok)
+		}
+	}
+}
+
+func TestWithoutExchange(t *testing.T) {
+	turns := testTurns("uauau")
+	stale := testTurns("ua")
+	stale[0].Content = "changed"
+	tests := []struct {
+		name     string
+		exchange []Turn
+		want     string
+	}{
+		{"last exchange", turns[2:4], "014"},
+		{"not a retry", nil, "01234"},
+		{"earlier exchange", turns[0:2], "01234"},
+		{"changed exchange", stale, "01234"},
+	}
+	for _, tt := range tests {
+		history := append([]Turn(nil), turns...)
+		if got := contents(WithoutExchange(history, tt.exchange)); got != tt.want {
+			t.Errorf("%s: WithoutExchange = %s, want %s", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(history, turns) {
+			t.Errorf("%s: WithoutExchange modified the history", tt.name)
+		}
+	}
+}
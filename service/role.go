@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+const (
+	// customRolesKey 用指令创建的角色
+	customRolesKey = "roles"
+	// globalRoleKey 用指令设置的全局默认角色
+	globalRoleKey = "role:global"
+)
+
+// rolesLock 创建、删除角色是读改写，加锁避免并发时丢失
+var rolesLock sync.Mutex
+
+// userRoleKey 私聊用户选择的角色
+func userRoleKey(id string) string {
+	return "role:user:" + id
+}
+
+// groupRoleKey 群选择的角色
+func groupRoleKey(id string) string {
+	return "role:group:" + id
+}
+
+// Roles 所有角色及其system prompt：配置的角色加上用指令创建的角色，同名时以配置为准
+func Roles(store Store) map[string]string {
+	roles := map[string]string{}
+	getJSON(store, customRolesKey, &roles)
+	for name, prompt := range config.LoadConfig().Roles {
+		roles[name] = prompt
+	}
+	return roles
+}
+
+// RoleNames 所有角色名，按名称排序
+func RoleNames(store Store) []string {
+	var names []string
+	for name := range Roles(store) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddRole 创建或修改角色，配置中的角色不能修改
+func AddRole(store Store, name, prompt string) error {
+	if _, ok := config.LoadConfig().Roles[name]; ok {
+		return fmt.Errorf("角色%s在配置中定义，不能修改", name)
+	}
+	rolesLock.Lock()
+	defer rolesLock.Unlock()
+	roles := map[string]string{}
+	getJSON(store, customRolesKey, &roles)
+	roles[name] = prompt
+	setJSON(store, customRolesKey, roles, 0)
+	return nil
+}
+
+// DeleteRole 删除用指令创建的角色，配置中的角色不能删除
+func DeleteRole(store Store, name string) error {
+	if _, ok := config.LoadConfig().Roles[name]; ok {
+		return fmt.Errorf("角色%s在配置中定义，不能删除", name)
+	}
+	rolesLock.Lock()
+	defer rolesLock.Unlock()
+	roles := map[string]string{}
+	getJSON(store, customRolesKey, &roles)
+	if _, ok := roles[name]; !ok {
+		return fmt.Errorf("没有角色%s", name)
+	}
+	delete(roles, name)
+	setJSON(store, customRolesKey, roles, 0)
+	return nil
+}
+
+// GlobalRole 全局默认角色，用指令设置过的以设置为准，否则使用配置的default_role
+func GlobalRole(store Store) string {
+	var name string
+	if getJSON(store, globalRoleKey, &name) {
+		return name
+	}
+	return config.LoadConfig().DefaultRole
+}
+
+// SetGlobalRole 设置全局默认角色，name为空时恢复配置的default_role
+func SetGlobalRole(store Store, name string) {
+	if name == "" {
+		deleteKey(store, globalRoleKey)
+		return
+	}
+	setJSON(store, globalRoleKey, name, 0)
+}
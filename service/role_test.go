@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+func TestSystemPrompt(t *testing.T) {
+	cfg := config.LoadConfig()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	cfg.Roles = map[string]string{"翻译": "你是翻译"}
+	cfg.DefaultRole = ""
+
+	store := NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	user := &openwechat.User{Uin: 1}
+	group := &openwechat.Group{User: &openwechat.User{Uin: 100}}
+	private := NewUserService(store, user)
+	member := NewGroupUserService(store, user, group)
+	if err := AddRole(store, "诗人", "你是诗人"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRole(store, "厨师", "你是厨师"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string, s UserServiceInterface, wantName, wantPrompt string) {
+		t.Helper()
+		if name, prompt := s.SystemPrompt(); name != wantName || prompt != wantPrompt {
+			t.Errorf("%s: SystemPrompt() = %q, %q, want %q, %q", step, name, prompt, wantName, wantPrompt)
+		}
+	}
+	check("no role", private, "", "")
+
+	// 全局默认角色：用指令设置的优先于配置的default_role
+	cfg.DefaultRole = "翻译"
+	check("configured default", private, "翻译", "你是翻译")
+	SetGlobalRole(store, "诗人")
+	check("global role", private, "诗人", "你是诗人")
+
+	// 私聊用户和群各自选择的角色优先于全局默认角色
+	private.SetRole("厨师")
+	check("user role", private, "厨师", "你是厨师")
+	check("group without role", member, "诗人", "你是诗人")
+	member.SetRole("翻译")
+	check("group role", member, "翻译", "你是翻译")
+	check("user role beside group role", private, "厨师", "你是厨师")
+
+	// 选择的角色被删除后使用全局默认角色，全局默认角色也被删除后不使用角色
+	if err := DeleteRole(store, "厨师"); err != nil {
+		t.Fatal(err)
+	}
+	check("deleted user role", private, "诗人", "你是诗人")
+	if err := DeleteRole(store, "诗人"); err != nil {
+		t.Fatal(err)
+	}
+	check("deleted global role", private, "", "")
+	SetGlobalRole(store, "")
+	check("global role reset to the configured default", private, "翻译", "你是翻译")
+}
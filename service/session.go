@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+const (
+	// DefaultSessionName 默认会话的显示名称，默认会话的历史直接保存在用户的key下
+	DefaultSessionName = "默认"
+	// maxSessions 每个用户最多创建的会话数，不含默认会话
+	maxSessions = 10
+	// maxSessionNameLength 会话名称最多多少个字符
+	maxSessionNameLength = 20
+)
+
+// SessionInfo 用户创建的一个会话
+type SessionInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// 当前是否在这个会话中，只在列出会话时填写
+	Active bool `json:"-"`
+	// 会话中的消息数，只在列出会话时填写
+	Turns int `json:"-"`
+}
+
+// sessionsKey 用户创建的会话列表的key
+func (s *UserService) sessionsKey() string {
+	return s.owner + ":sessions"
+}
+
+// activeKey 用户当前所在会话的key
+func (s *UserService) activeKey() string {
+	return s.owner + ":active"
+}
+
+// sessionKey 会话历史的key，默认会话直接用owner
+func (s *UserService) sessionKey(name string) string {
+	if name == "" {
+		return s.owner
+	}
+	return s.owner + "#" + name
+}
+
+// isDefaultSession 是否指默认会话
+func isDefaultSession(name string) bool {
+	return name == "" || name == DefaultSessionName || name == "default"
+}
+
+// ActiveSession 当前所在的会话名称，默认会话返回空
+func (s *UserService) ActiveSession() string {
+	var name string
+	s.get(s.activeKey(), &name)
+	return name
+}
+
+// Sessions 列出默认会话和创建的会话
+func (s *UserService) Sessions() []SessionInfo {
+	active := s.ActiveSession()
+	sessions := append([]SessionInfo{{Name: ""}}, s.namedSessions()...)
+	for i := range sessions {
+		var turns []Turn
+		s.get(s.sessionKey(sessions[i].Name), &turns)
+		sessions[i].Turns = len(turns)
+		sessions[i].Active = sessions[i].Name == active
+	}
+	return sessions
+}
+
+// NewSession 创建会话并切换过去
+func (s *UserService) NewSession(name string) error {
+	if err := validSessionName(name); err != nil {
+		return err
+	}
+	lock := sessionLock(s.sessionsKey())
+	lock.Lock()
+	defer lock.Unlock()
+	sessions := s.namedSessions()
+	if findSession(sessions, name) >= 0 {
+		return fmt.Errorf("会话%s已存在", name)
+	}
+	if len(sessions) >= maxSessions {
+		return fmt.Errorf("最多创建%d个会话，请先删除不用的会话", maxSessions)
+	}
+	s.set(s.sessionsKey(), append(sessions, SessionInfo{Name: name, CreatedAt: time.Now()}), 0)
+	s.switchTo(name)
+	return nil
+}
+
+// SwitchSession 切换到已有的会话，name为默认会话时回到默认会话
+func (s *UserService) SwitchSession(name string) error {
+	lock := sessionLock(s.sessionsKey())
+	lock.Lock()
+	defer lock.Unlock()
+	if isDefaultSession(name) {
+		s.switchTo("")
+		return nil
+	}
+	if findSession(s.namedSessions(), name) < 0 {
+		return fmt.Errorf("没有会话%s", name)
+	}
+	s.switchTo(name)
+	return nil
+}
+
+// RenameSession 重命名创建的会话，会话历史一起搬到新名称下
+func (s *UserService) RenameSession(name, newName string) error {
+	if isDefaultSession(name) {
+		return fmt.Errorf("默认会话不能重命名")
+	}
+	if err := validSessionName(newName); err != nil {
+		return err
+	}
+	lock := sessionLock(s.sessionsKey())
+	lock.Lock()
+	defer lock.Unlock()
+	sessions := s.namedSessions()
+	index := findSession(sessions, name)
+	if index < 0 {
+		return fmt.Errorf("没有会话%s", name)
+	}
+	if findSession(sessions, newName) >= 0 {
+		return fmt.Errorf("会话%s已存在", newName)
+	}
+
+	var turns []Turn
+	if s.get(s.sessionKey(name), &turns) {
+		s.set(s.sessionKey(newName), turns, time.Second*config.LoadConfig().SessionTimeout)
+	}
+	s.delete(s.sessionKey(name))
+	sessions[index].Name = newName
+	s.set(s.sessionsKey(), sessions, 0)
+	if s.ActiveSession() == name {
+		s.switchTo(newName)
+	}
+	return nil
+}
+
+// DeleteSession 删除创建的会话及其历史，删除当前会话时回到默认会话，默认会话只能清空
+func (s *UserService) DeleteSession(name string) error {
+	if isDefaultSession(name) {
+		return fmt.Errorf("默认会话不能删除，发送清空口令可以清空")
+	}
+	lock := sessionLock(s.sessionsKey())
+	lock.Lock()
+	defer lock.Unlock()
+	sessions := s.namedSessions()
+	index := findSession(sessions, name)
+	if index < 0 {
+		return fmt.Errorf("没有会话%s", name)
+	}
+	s.delete(s.sessionKey(name))
+	s.set(s.sessionsKey(), append(sessions[:index], sessions[index+1:]...), 0)
+	if s.ActiveSession() == name {
+		s.switchTo("")
+	}
+	return nil
+}
+
+// switchTo 记下当前所在的会话，之后的历史读写都在这个会话中，调用方持有会话列表的锁
+func (s *UserService) switchTo(name string) {
+	if name == "" {
+		s.delete(s.activeKey())
+	} else {
+		s.set(s.activeKey(), name, 0)
+	}
+	s.session = s.sessionKey(name)
+}
+
+// namedSessions 用户创建的会话，按创建顺序
+func (s *UserService) namedSessions() []SessionInfo {
+	var sessions []SessionInfo
+	s.get(s.sessionsKey(), &sessions)
+	return sessions
+}
+
+// findSession 查找会话的下标，没有时返回-1
+func findSession(sessions []SessionInfo, name string) int {
+	for i, session := range sessions {
+		if session.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// validSessionName 检查会话名称
+func validSessionName(name string) error {
+	if isDefaultSession(name) {
+		return fmt.Errorf("%s是默认会话的名称，请换一个", DefaultSessionName)
+	}
+	if utf8.RuneCountInString(name) > maxSessionNameLength {
+		return fmt.Errorf("会话名称最多%d个字", maxSessionNameLength)
+	}
+	return nil
+}
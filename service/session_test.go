@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// sessionNames 会话名称以及当前会话，当前会话前面加*
+func sessionNames(s UserServiceInterface) string {
+	var names []string
+	for _, session := range s.Sessions() {
+		name := session.Name
+		if session.Active {
+			name = "*" + name
+		}
+		names = append(names, fmt.Sprintf("%s(%d)", name, session.Turns))
+	}
+	return fmt.Sprint(names)
+}
+
+func TestSessions(t *testing.T) {
+	store := NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	user := &openwechat.User{Uin: 1}
+	s := NewUserService(store, user)
+	s.AppendHistory(NewTurn(gpt.RoleUser, "默认会话的问题"))
+
+	// 创建会话后切换过去，历史各自保存
+	if err := s.NewSession("工作"); err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q1"), NewTurn(gpt.RoleAssistant, "a1"))
+	if err := s.NewSession("工作"); err == nil {
+		t.Errorf("NewSession() with an existing name = nil, want an error")
+	}
+	if err := s.NewSession(DefaultSessionName); err == nil {
+		t.Errorf("NewSession() with the default name = nil, want an error")
+	}
+	if got := sessionNames(s); got != "[(1) *工作(2)]" {
+		t.Fatalf("sessions = %s, want the new session active", got)
+	}
+
+	// 当前会话保存在存储中，重新创建业务层后仍在这个会话
+	s = NewUserService(store, user)
+	if contents(s.GetHistory()) != "q1a1" {
+		t.Fatalf("history after reloading = %s, want q1a1", contents(s.GetHistory()))
+	}
+	if err := s.SwitchSession("不存在"); err == nil {
+		t.Errorf("SwitchSession() to a missing session = nil, want an error")
+	}
+	if err := s.SwitchSession(DefaultSessionName); err != nil || contents(s.GetHistory()) != "默认会话的问题" {
+		t.Fatalf("SwitchSession(默认) = %v, history %s, want the default history", err, contents(s.GetHistory()))
+	}
+
+	// 重命名时历史一起搬过去
+	if err := s.RenameSession("工作", "项目"); err != nil {
+		t.Fatalf("RenameSession() error: %v", err)
+	}
+	if err := s.RenameSession(DefaultSessionName, "其他"); err == nil {
+		t.Errorf("RenameSession() of the default session = nil, want an error")
+	}
+	if err := s.SwitchSession("项目"); err != nil || contents(s.GetHistory()) != "q1a1" {
+		t.Fatalf("SwitchSession(项目) = %v, history %s, want q1a1", err, contents(s.GetHistory()))
+	}
+
+	// 默认会话不能删除，删除当前会话时回到默认会话
+	for _, name := range []string{DefaultSessionName, "default", ""} {
+		if err := s.DeleteSession(name); err == nil {
+			t.Errorf("DeleteSession(%q) = nil, want the default session kept", name)
+		}
+	}
+	if err := s.DeleteSession("项目"); err != nil {
+		t.Fatalf("DeleteSession() error: %v", err)
+	}
+	if got := sessionNames(s); got != "[*(1)]" || s.ActiveSession() != "" {
+		t.Fatalf("sessions after deleting = %s, want only the default session", got)
+	}
+	if err := s.DeleteSession("项目"); err == nil {
+		t.Errorf("DeleteSession() of a deleted session = nil, want an error")
+	}
+	// 同名会话重新创建时没有之前的历史
+	s.NewSession("项目")
+	if history := s.GetHistory(); len(history) != 0 {
+		t.Errorf("history of a recreated session = %s, want empty", contents(history))
+	}
+}
+
+func TestSessionsLimit(t *testing.T) {
+	s := newTestUserService(t)
+	if err := s.NewSession("一个很长很长很长很长很长很长很长很长的会话名称"); err == nil {
+		t.Errorf("NewSession() with a long name = nil, want an error")
+	}
+	for i := 0; i < maxSessions; i++ {
+		if err := s.NewSession(fmt.Sprintf("会话%d", i)); err != nil {
+			t.Fatalf("NewSession(%d) error: %v", i, err)
+		}
+	}
+	if err := s.NewSession("再来一个"); err == nil {
+		t.Errorf("NewSession() beyond %d sessions = nil, want an error", maxSessions)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import "github.com/qingconglaixueit/wechatbot/config"
+
+// speechKey 语音回复开关的key，私聊按用户ID，群聊按群ID
+func speechKey(id string) string {
+	return "speech:" + id
+}
+
+// SpeechReplyEnabled 是否用语音回复，用指令设置过的以设置为准，否则使用配置的speech_reply
+func SpeechReplyEnabled(store Store, id string) bool {
+	var on bool
+	if getJSON(store, speechKey(id), &on) {
+		return on
+	}
+	return config.LoadConfig().SpeechReply
+}
+
+// SetSpeechReply 设置语音回复开关，不过期
+func SetSpeechReply(store Store, id string, on bool) {
+	lock := sessionLock(speechKey(id))
+	lock.Lock()
+	defer lock.Unlock()
+	setJSON(store, speechKey(id), on, 0)
+}
+
+// ToggleSpeechReply 切换语音回复开关，返回切换后的状态
+func ToggleSpeechReply(store Store, id string) bool {
+	lock := sessionLock(speechKey(id))
+	lock.Lock()
+	defer lock.Unlock()
+	on := !SpeechReplyEnabled(store, id)
+	setJSON(store, speechKey(id), on, 0)
+	return on
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+const (
+	// StoreMemory 内存存储，重启后会话丢失
+	StoreMemory = "memory"
+	// StoreBolt bbolt本地文件存储
+	StoreBolt = "bolt"
+	// StoreRedis Redis存储，多个实例可以共享会话
+	StoreRedis = "redis"
+)
+
+// storeCleanupInterval 清理过期数据的间隔
+const storeCleanupInterval = time.Minute * 5
+
+// Store 会话存储，保存序列化后的数据，ttl不大于0时不过期
+type Store interface {
+	// Get 获取key对应的数据，不存在或已过期时返回false
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Close() error
+}
+
+var (
+	store     Store
+	storeOnce sync.Once
+	closeOnce sync.Once
+)
+
+// SessionStore 获取全局会话存储，首次调用时按配置创建，创建失败时使用内存存储
+func SessionStore() Store {
+	storeOnce.Do(func() {
+		var err error
+		store, err = NewStore(config.LoadConfig())
+		if err != nil {
+			logger.Danger(fmt.Sprintf("create session store error: %v, use memory store", err))
+			store = NewMemoryStore()
+		}
+	})
+	return store
+}
+
+// CloseSessionStore 退出时关闭全局会话存储，先等后台的压缩写回，bolt写完数据并释放文件锁，没有创建过时不再创建
+func CloseSessionStore() {
+	closeOnce.Do(func() {
+		StopSummaries()
+		storeOnce.Do(func() {})
+		if store == nil {
+			return
+		}
+		if err := store.Close(); err != nil {
+			logger.Warning(fmt.Sprintf("close session store error: %v", err))
+		}
+	})
+}
+
+// NewStore 按配置的session_store创建会话存储
+func NewStore(cfg *config.Configuration) (Store, error) {
+	switch strings.ToLower(cfg.SessionStore) {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreBolt:
+		return NewBoltStore(cfg.SessionFile)
+	case StoreRedis:
+		return NewRedisStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	}
+	return nil, fmt.Errorf("unknown session store: %s", cfg.SessionStore)
+}
+
+// getJSON 读取并反序列化存储中的数据，不存在或出错时返回false，出错只记录日志
+func getJSON(store Store, key string, value interface{}) bool {
+	data, ok, err := store.Get(key)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("get session %s error: %v", key, err))
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err = json.Unmarshal(data, value); err != nil {
+		logger.Warning(fmt.Sprintf("unmarshal session %s error: %v", key, err))
+		return false
+	}
+	return true
+}
+
+// setJSON 序列化后写入存储，ttl不大于0时不过期
+func setJSON(store Store, key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("marshal session %s error: %v", key, err))
+		return
+	}
+	if err = store.Set(key, data, ttl); err != nil {
+		logger.Warning(fmt.Sprintf("set session %s error: %v", key, err))
+	}
+}
+
+// deleteKey 从存储中删除，出错只记录日志
+func deleteKey(store Store, key string) {
+	if err := store.Delete(key); err != nil {
+		logger.Warning(fmt.Sprintf("delete session %s error: %v", key, err))
+	}
+}
+
+// MemoryStore 基于go-cache的内存存储
+type MemoryStore struct {
+	cache *cache.Cache
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{cache: cache.New(cache.NoExpiration, storeCleanupInterval)}
+}
+
+func (m *MemoryStore) Get(key string) ([]byte, bool, error) {
+	value, ok := m.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return value.([]byte), true, nil
+}
+
+func (m *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	m.cache.Set(key, value, ttl)
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.cache.Delete(key)
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStoreContract 各种存储都要满足的行为
+func testStoreContract(t *testing.T, store Store) {
+	if _, ok, err := store.Get("missing"); ok || err != nil {
+		t.Fatalf("Get(missing) = %v, %v, want false, nil", ok, err)
+	}
+
+	if err := store.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	value, ok, err := store.Get("key")
+	if !ok || err != nil || string(value) != "value" {
+		t.Fatalf("Get(key) = %q, %v, %v, want value, true, nil", value, ok, err)
+	}
+
+	if err = store.Set("key", []byte("new"), 0); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if value, _, _ = store.Get("key"); string(value) != "new" {
+		t.Fatalf("Get(key) after overwrite = %q, want new", value)
+	}
+
+	if err = store.Delete("key"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if _, ok, _ = store.Get("key"); ok {
+		t.Fatal("Get(key) after Delete found the value")
+	}
+	if err = store.Delete("key"); err != nil {
+		t.Fatalf("Delete missing key error: %v", err)
+	}
+
+	if err = store.Set("short", []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if err = store.Set("long", []byte("value"), time.Hour); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if _, ok, _ = store.Get("short"); !ok {
+		t.Fatal("Get(short) before expiry found nothing")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, _ = store.Get("short"); ok {
+		t.Fatal("Get(short) after expiry found the value")
+	}
+	if _, ok, _ = store.Get("long"); !ok {
+		t.Fatal("Get(long) expired early")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	testStoreContract(t, store)
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testStoreContract(t, store)
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := NewBoltStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set("key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(file)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer store.Close()
+	if value, ok, _ := store.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("Get(key) after reopen = %q, %v, want value, true", value, ok)
+	}
+}
+
+func TestBoltStoreDeletesExpired(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.Set("key", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok, _ := store.Get("key"); ok {
+		t.Fatal("Get(key) after expiry found the value")
+	}
+	// 读到过期数据时顺手删除，文件里不再保留
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltBucket).Get([]byte("key")) != nil {
+			t.Error("expired key still in the file")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want bool
+	}{
+		{"no expiry", 0, false},
+		{"future", time.Hour, false},
+		{"past", -time.Hour, true},
+	}
+	for _, tt := range tests {
+		data := make([]byte, 8)
+		if tt.ttl != 0 {
+			binary.BigEndian.PutUint64(data, uint64(time.Now().Add(tt.ttl).UnixNano()))
+		}
+		if got := isExpired(data); got != tt.want {
+			t.Errorf("%s: isExpired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+const (
+	// summaryPrefix 摘要消息的开头，告诉模型这是之前对话的摘要
+	summaryPrefix = "之前对话的摘要："
+	// summaryPrompt 压缩对话的要求
+	summaryPrompt = "请把下面的对话压缩成一份简洁的摘要，供之后继续对话时参考。" +
+		"保留用户的目标、关键事实、已经得出的结论、重要的代码或命令以及还没解决的问题，" +
+		"群聊中保留谁说了什么，不要添加对话中没有的内容，用中文输出，不超过500字。"
+)
+
+var (
+	// sessionLocks 每个key一把锁，并发处理同一用户的消息时读改写不会互相覆盖，如压缩和追加历史
+	sessionLocks sync.Map
+	// summarizing 正在压缩的会话，同一个会话同时只压缩一次
+	summarizing sync.Map
+	// summaries 后台进行中的压缩，退出时取消请求并等待写回结束，再关闭会话存储
+	summaries = newSummaryGroup()
+)
+
+// summaryGroup 后台压缩任务，停止后不再开始新的压缩
+type summaryGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	lock   sync.Mutex
+	wait   sync.WaitGroup
+}
+
+func newSummaryGroup() *summaryGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &summaryGroup{ctx: ctx, cancel: cancel}
+}
+
+// start 开始一次压缩，已经停止时返回false
+func (g *summaryGroup) start() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.ctx.Err() != nil {
+		return false
+	}
+	g.wait.Add(1)
+	return true
+}
+
+// stop 取消进行中的压缩请求并等待它们结束
+func (g *summaryGroup) stop() {
+	g.lock.Lock()
+	g.cancel()
+	g.lock.Unlock()
+	g.wait.Wait()
+}
+
+// StopSummaries 退出时取消后台进行中的压缩并等待结束，被取消的压缩不改动会话历史
+func StopSummaries() {
+	summaries.stop()
+}
+
+// sessionLock 获取会话或其他key的锁
+func sessionLock(session string) *sync.Mutex {
+	lock, _ := sessionLocks.LoadOrStore(session, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// historyLimit 会话历史最多保留的token数，配置的history_max_tokens和默认模型的上下文窗口取较小的
+func historyLimit() int {
+	cfg := config.LoadConfig()
+	limit := gpt.PromptBudget(cfg.Model, cfg.MaxTokens)
+	if cfg.HistoryMaxTokens > 0 && cfg.HistoryMaxTokens < limit {
+		limit = cfg.HistoryMaxTokens
+	}
+	return limit
+}
+
+// countTurnsTokens 会话历史的token总数
+func countTurnsTokens(turns []Turn) int {
+	tokens := 0
+	for _, turn := range turns {
+		tokens += turn.Tokens
+	}
+	return tokens
+}
+
+// NewSummaryTurn 创建摘要消息，作为system消息放在会话历史最前面
+func NewSummaryTurn(summary string) Turn {
+	turn := newTurn(gpt.RoleSystem, "", summaryPrefix+summary)
+	turn.Summary = true
+	return turn
+}
+
+// splitForSummary 把会话历史拆成要压缩的较早部分和保留的最近部分，最近部分最多占上限的一半，
+// 已有的摘要归入较早部分，和新压缩的内容合并成一份
+func splitForSummary(turns []Turn, limit int) (old, recent []Turn) {
+	start := 0
+	for start < len(turns) && turns[start].Role == gpt.RoleSystem {
+		start++
+	}
+	recent = TrimTurns(turns[start:], limit/2)
+	return turns[:len(turns)-len(recent)], recent
+}
+
+// transcript 把要压缩的对话整理成文本
+func transcript(turns []Turn) string {
+	var b strings.Builder
+	for _, turn := range turns {
+		switch {
+		case turn.Summary:
+			b.WriteString(turn.Content)
+		case turn.Role == gpt.RoleAssistant:
+			b.WriteString("助手：" + turn.Content)
+		case turn.Name != "":
+			b.WriteString(turn.Name + "：" + turn.Content)
+		default:
+			b.WriteString("用户：" + turn.Content)
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// sameTurns 会话历史开头是否仍然是这些消息，压缩期间会话被清空或修改过时不能写回
+func sameTurns(turns, prefix []Turn) bool {
+	if len(turns) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if turns[i].Role != prefix[i].Role || turns[i].Content != prefix[i].Content || !turns[i].Timestamp.Equal(prefix[i].Timestamp) {
+			return false
+		}
+	}
+	return true
+}
+
+// summarize 在后台把会话历史中较早的部分压缩成摘要，放在会话历史最前面，失败时丢弃较早的部分
+func (s *UserService) summarize() {
+	session := s.session
+	if _, running := summarizing.LoadOrStore(session, true); running {
+		return
+	}
+	if !summaries.start() {
+		summarizing.Delete(session)
+		return
+	}
+	go func() {
+		defer summaries.wait.Done()
+		defer summarizing.Delete(session)
+
+		// 1.拆出要压缩的部分，调用模型时不持有锁
+		lock := sessionLock(session)
+		lock.Lock()
+		old, _ := splitForSummary(s.GetHistory(), historyLimit())
+		lock.Unlock()
+		if len(old) == 0 {
+			return
+		}
+		summary, err := s.requestSummary(summaries.ctx, old)
+		if summaries.ctx.Err() != nil {
+			// 退出时被取消，会话历史保持原样
+			return
+		}
+
+		// 2.写回时会话开头没有变化才替换，期间追加的新消息保留
+		lock.Lock()
+		defer lock.Unlock()
+		turns := s.GetHistory()
+		if !sameTurns(turns, old) {
+			return
+		}
+		turns = turns[len(old):]
+		if err != nil {
+			logger.Warning(fmt.Sprintf("summarize history error: %v", err))
+		} else {
+			turns = append([]Turn{NewSummaryTurn(summary)}, turns...)
+			log.Printf("history summarized: %d turns -> %s\n", len(old), summary)
+		}
+		s.setHistory(turns)
+	}()
+}
+
+// requestSummary 请求模型压缩对话，用量记在发起会话的用户和群上，ctx取消时中断请求
+func (s *UserService) requestSummary(ctx context.Context, turns []Turn) (string, error) {
+	cfg := config.LoadConfig()
+	model := cfg.SummaryModel
+	if model == "" {
+		model = cfg.Model
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second*cfg.RequestTimeout)
+	defer cancel()
+	messages := []gpt.Message{
+		{Role: gpt.RoleSystem, Content: summaryPrompt},
+		{Role: gpt.RoleUser, Content: transcript(turns)},
+	}
+	summary, usage, err := gpt.ChatCompletions(ctx, gpt.NewModelSettings(model), messages)
+	if usage.PromptTokens+usage.CompletionTokens > 0 {
+		var groupID, groupName string
+		if s.group != nil {
+			groupID, groupName = s.group.ID(), s.group.NickName
+		}
+		Ledger().Record(usage, s.user.ID(), s.user.NickName, groupID, groupName)
+	}
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// useTestAPI 把模型接口指向本地的测试服务，压缩历史的上限调小，测试结束后恢复配置
+func useTestAPI(t *testing.T, handler http.HandlerFunc) *config.Configuration {
+	server := httptest.NewServer(handler)
+	cfg := config.LoadConfig()
+	saved := *cfg
+	cfg.Provider, cfg.BaseURL, cfg.ApiKey, cfg.ApiKeys = gpt.ProviderOpenAI, server.URL, "test-key", nil
+	cfg.KeyProbeInterval, cfg.UsageFile, cfg.Fallbacks, cfg.MaxContinuations = 0, "", nil, 0
+	cfg.SummarizeHistory, cfg.HistoryMaxTokens = true, 100
+	t.Cleanup(func() {
+		server.Close()
+		*cfg = saved
+	})
+	return cfg
+}
+
+// useTestSummaries 使用单独的后台压缩任务，停止它不影响其他测试
+func useTestSummaries(t *testing.T) {
+	saved := summaries
+	summaries = newSummaryGroup()
+	t.Cleanup(func() {
+		summaries.stop()
+		summaries = saved
+	})
+}
+
+// appendLongHistory 追加足够多的问答，超过压缩的上限
+func appendLongHistory(s UserServiceInterface) {
+	for i := 0; i < 6; i++ {
+		s.AppendHistory(NewTurn(gpt.RoleUser, fmt.Sprintf("第%d个问题，内容稍微长一点", i)),
+			NewTurn(gpt.RoleAssistant, fmt.Sprintf("第%d个回答，内容也稍微长一点", i)))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	useTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"之前聊了几个问题"},"finish_reason":"stop"}]}`)
+	})
+	useTestSummaries(t)
+	s := newTestUserService(t)
+
+	appendLongHistory(s)
+	summaries.wait.Wait()
+	history := s.GetHistory()
+	if len(history) == 0 || !history[0].Summary || !strings.Contains(history[0].Content, "之前聊了几个问题") {
+		t.Fatalf("history after summarizing = %+v, want a summary first", history)
+	}
+	if last := history[len(history)-1]; last.Content != "第5个回答，内容也稍微长一点" {
+		t.Fatalf("latest turn = %q, want the last answer kept", last.Content)
+	}
+}
+
+func TestStopSummaries(t *testing.T) {
+	started := make(chan struct{}, 1)
+	useTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体，客户端断开时服务端才能感知到
+		io.Copy(io.Discard, r.Body)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	})
+	useTestSummaries(t)
+	s := newTestUserService(t)
+
+	appendLongHistory(s)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("summary request not sent")
+	}
+	before := contents(s.GetHistory())
+
+	// 退出时取消进行中的压缩并等它结束，会话历史保持原样
+	stopped := make(chan struct{})
+	go func() {
+		StopSummaries()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopSummaries did not return")
+	}
+	if after := contents(s.GetHistory()); after != before {
+		t.Fatalf("history changed by a canceled summary:\n%s\nwant\n%s", after, before)
+	}
+
+	// 停止后不再开始新的压缩
+	if summaries.start() {
+		t.Fatal("summary started after StopSummaries")
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+const (
+	// usageDateLayout 用量按天记录的日期格式
+	usageDateLayout = "2006-01-02"
+	// usageKeepDays 用量记录保留天数
+	usageKeepDays = 90
+)
+
+// UsageRecord 一个用户或群一天的用量
+type UsageRecord struct {
+	// 用户或群昵称
+	Name             string `json:"name"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	// 估算费用，美元
+	Cost float64 `json:"cost"`
+	// 各模型的token用量
+	Models map[string]int `json:"models"`
+}
+
+// TotalTokens token总数
+func (r *UsageRecord) TotalTokens() int {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// add 累加一次请求的用量
+func (r *UsageRecord) add(name string, usage gpt.Usage, cost float64) {
+	if name != "" {
+		r.Name = name
+	}
+	r.Requests++
+	r.PromptTokens += usage.PromptTokens
+	r.CompletionTokens += usage.CompletionTokens
+	r.Cost += cost
+	if r.Models == nil {
+		r.Models = map[string]int{}
+	}
+	r.Models[usage.Model] += usage.PromptTokens + usage.CompletionTokens
+}
+
+// merge 合并另一条记录
+func (r *UsageRecord) merge(other *UsageRecord) {
+	r.Name = other.Name
+	r.Requests += other.Requests
+	r.PromptTokens += other.PromptTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.Cost += other.Cost
+	if r.Models == nil {
+		r.Models = map[string]int{}
+	}
+	for model, tokens := range other.Models {
+		r.Models[model] += tokens
+	}
+}
+
+// UsageEntry 用量排行中的一项
+type UsageEntry struct {
+	Key string
+	UsageRecord
+}
+
+// UsageLedger 按天记录每个用户、每个群的用量，每次记录后写入文件
+type UsageLedger struct {
+	lock sync.Mutex
+	file string
+	// 日期 -> 用户或群的key -> 用量
+	days map[string]map[string]*UsageRecord
+}
+
+var (
+	ledger     *UsageLedger
+	ledgerOnce sync.Once
+)
+
+// Ledger 获取全局用量账本，首次调用时从配置的文件加载
+func Ledger() *UsageLedger {
+	ledgerOnce.Do(func() {
+		ledger = NewUsageLedger(config.LoadConfig().UsageFile)
+	})
+	return ledger
+}
+
+// NewUsageLedger 创建用量账本，file为空时只记录在内存中
+func NewUsageLedger(file string) *UsageLedger {
+	l := &UsageLedger{file: file, days: map[string]map[string]*UsageRecord{}}
+	if file == "" {
+		return l
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning(fmt.Sprintf("read usage file error: %v", err))
+		}
+		return l
+	}
+	if err = json.Unmarshal(data, &l.days); err != nil {
+		logger.Warning(fmt.Sprintf("parse usage file error: %v", err))
+		l.days = map[string]map[string]*UsageRecord{}
+	}
+	return l
+}
+
+// UserUsageKey 用户在账本中的key
+func UserUsageKey(id string) string {
+	return "user:" + id
+}
+
+// GroupUsageKey 群在账本中的key
+func GroupUsageKey(id string) string {
+	return "group:" + id
+}
+
+// Record 记录一次请求的用量，同时计入用户和所在的群，返回估算费用
+func (l *UsageLedger) Record(usage gpt.Usage, user, userName, group, groupName string) float64 {
+	cost := gpt.EstimateCost(usage)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	date := time.Now().Format(usageDateLayout)
+	day, ok := l.days[date]
+	if !ok {
+		day = map[string]*UsageRecord{}
+		l.days[date] = day
+	}
+	l.record(day, UserUsageKey(user), userName, usage, cost)
+	if group != "" {
+		l.record(day, GroupUsageKey(group), groupName, usage, cost)
+	}
+	l.prune()
+	l.save()
+	return cost
+}
+
+// Sum 汇总key从since到今天的用量
+func (l *UsageLedger) Sum(key string, since time.Time) UsageRecord {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var sum UsageRecord
+	from := since.Format(usageDateLayout)
+	for date, day := range l.days {
+		if date < from {
+			continue
+		}
+		if record, ok := day[key]; ok {
+			sum.merge(record)
+		}
+	}
+	return sum
+}
+
+// Report 某天所有用户和群的用量，按费用从高到低排序
+func (l *UsageLedger) Report(date time.Time) []UsageEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var entries []UsageEntry
+	for key, record := range l.days[date.Format(usageDateLayout)] {
+		entries = append(entries, UsageEntry{Key: key, UsageRecord: *record})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Cost != entries[j].Cost {
+			return entries[i].Cost > entries[j].Cost
+		}
+		return entries[i].TotalTokens() > entries[j].TotalTokens()
+	})
+	return entries
+}
+
+func (l *UsageLedger) record(day map[string]*UsageRecord, key, name string, usage gpt.Usage, cost float64) {
+	record, ok := day[key]
+	if !ok {
+		record = &UsageRecord{}
+		day[key] = record
+	}
+	record.add(name, usage, cost)
+}
+
+// prune 删除超过保留天数的记录
+func (l *UsageLedger) prune() {
+	expired := time.Now().AddDate(0, 0, -usageKeepDays).Format(usageDateLayout)
+	for date := range l.days {
+		if date < expired {
+			delete(l.days, date)
+		}
+	}
+}
+
+// save 先写临时文件再替换，避免写一半时退出把记录写坏
+func (l *UsageLedger) save() {
+	if l.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(l.days, "", "  ")
+	if err != nil {
+		logger.Warning(fmt.Sprintf("marshal usage error: %v", err))
+		return
+	}
+	tmp := l.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		logger.Warning(fmt.Sprintf("write usage file error: %v", err))
+		return
+	}
+	if err = os.Rename(tmp, l.file); err != nil {
+		logger.Warning(fmt.Sprintf("rename usage file error: %v", err))
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// useTestPrices 使用配置的模型价格，测试结束后恢复
+func useTestPrices(t *testing.T, prices map[string]config.ModelPrice) {
+	cfg := config.LoadConfig()
+	saved := cfg.ModelPrices
+	cfg.ModelPrices = prices
+	t.Cleanup(func() { cfg.ModelPrices = saved })
+}
+
+func floatEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestUsageLedgerRecord(t *testing.T) {
+	useTestPrices(t, map[string]config.ModelPrice{"my-model": {Prompt: 0.01, Completion: 0.02}})
+	l := NewUsageLedger("")
+
+	// 配置的价格优先，其次按前缀匹配内置价格，未知模型按0计算
+	tests := []struct {
+		usage gpt.Usage
+		want  float64
+	}{
+		{gpt.Usage{Model: "my-model", PromptTokens: 1000, CompletionTokens: 500}, 0.01 + 0.01},
+		{gpt.Usage{Model: "gpt-4-0613", PromptTokens: 1000, CompletionTokens: 1000}, 0.03 + 0.06},
+		{gpt.Usage{Model: "gpt-4o-mini", PromptTokens: 2000}, 0.0003},
+		{gpt.Usage{Model: "unknown", PromptTokens: 1000, CompletionTokens: 1000}, 0},
+	}
+	for _, tt := range tests {
+		if cost := l.Record(tt.usage, "u1", "小明", "", ""); !floatEqual(cost, tt.want) {
+			t.Errorf("Record(%+v) cost = %v, want %v", tt.usage, cost, tt.want)
+		}
+	}
+}
+
+func TestUsageLedgerDaily(t *testing.T) {
+	useTestPrices(t, map[string]config.ModelPrice{"m": {Prompt: 1, Completion: 1}})
+	l := NewUsageLedger("")
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	// 之前几天的记录
+	lastWeek := today.AddDate(0, 0, -7).Format(usageDateLayout)
+	l.days[lastWeek] = map[string]*UsageRecord{
+		UserUsageKey("u1"): {Name: "小明", Requests: 2, PromptTokens: 100, CompletionTokens: 100, Cost: 0.2, Models: map[string]int{"m": 200}},
+	}
+	expired := today.AddDate(0, 0, -usageKeepDays-1).Format(usageDateLayout)
+	l.days[expired] = map[string]*UsageRecord{UserUsageKey("u1"): {Requests: 1}}
+
+	// 今天的用量同时计入用户和群
+	l.Record(gpt.Usage{Model: "m", PromptTokens: 1000, CompletionTokens: 1000}, "u1", "小明", "g1", "测试群")
+	l.Record(gpt.Usage{Model: "m", PromptTokens: 500}, "u1", "小明改名", "g1", "测试群")
+	l.Record(gpt.Usage{Model: "m", PromptTokens: 100}, "u2", "小红", "", "")
+
+	sum := l.Sum(UserUsageKey("u1"), today)
+	if sum.Requests != 2 || sum.PromptTokens != 1500 || sum.CompletionTokens != 1000 || !floatEqual(sum.Cost, 2.5) {
+		t.Errorf("u1 today = %+v, want 2 requests, 1500+1000 tokens, $2.5", sum)
+	}
+	if sum.Name != "小明改名" || sum.Models["m"] != 2500 {
+		t.Errorf("u1 today name %q models %v, want the latest name and 2500 tokens on m", sum.Name, sum.Models)
+	}
+	if group := l.Sum(GroupUsageKey("g1"), today); group.Requests != 2 || group.Name != "测试群" {
+		t.Errorf("g1 today = %+v, want 2 requests", group)
+	}
+	if month := l.Sum(UserUsageKey("u1"), today.AddDate(0, 0, -10)); month.Requests != 4 || month.TotalTokens() != 2700 {
+		t.Errorf("u1 since 10 days ago = %+v, want 4 requests and 2700 tokens", month)
+	}
+	if _, ok := l.days[expired]; ok {
+		t.Errorf("records older than %d days were kept", usageKeepDays)
+	}
+
+	// 按费用从高到低
+	report := l.Report(now)
+	var keys []string
+	for _, entry := range report {
+		keys = append(keys, entry.Key)
+	}
+	if len(keys) != 3 || keys[2] != UserUsageKey("u2") {
+		t.Errorf("report keys = %v, want u2 last", keys)
+	}
+	if report := l.Report(today.AddDate(0, 0, -1)); len(report) != 0 {
+		t.Errorf("report for yesterday = %+v, want empty", report)
+	}
+}
+
+func TestUsageLedgerFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.json")
+	l := NewUsageLedger(file)
+	l.Record(gpt.Usage{Model: "gpt-4", PromptTokens: 10, CompletionTokens: 20}, "u1", "小明", "g1", "测试群")
+
+	// 重新加载后用量不变，并且继续累加
+	loaded := NewUsageLedger(file)
+	today := time.Now().AddDate(0, 0, -1)
+	if got, want := loaded.Sum(UserUsageKey("u1"), today), l.Sum(UserUsageKey("u1"), today); got.Requests != 1 || got.TotalTokens() != 30 ||
+		!floatEqual(got.Cost, want.Cost) || got.Models["gpt-4"] != 30 || got.Name != "小明" {
+		t.Fatalf("loaded usage = %+v, want %+v", got, want)
+	}
+	loaded.Record(gpt.Usage{Model: "gpt-4", PromptTokens: 10}, "u1", "小明", "", "")
+	if got := NewUsageLedger(file).Sum(UserUsageKey("u1"), today); got.Requests != 2 {
+		t.Errorf("usage after reloading twice = %+v, want 2 requests", got)
+	}
+
+	// 文件不存在或者损坏时从空账本开始
+	broken := filepath.Join(t.TempDir(), "broken.json")
+	if err := os.WriteFile(broken, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{filepath.Join(t.TempDir(), "missing.json"), broken} {
+		if got := NewUsageLedger(file).Report(time.Now()); len(got) != 0 {
+			t.Errorf("ledger from %s = %+v, want empty", filepath.Base(file), got)
+		}
+	}
+}
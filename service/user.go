@@ -2,61 +2,334 @@ package service
 
 import (
 	"github.com/eatmoreapple/openwechat"
-	"github.com/patrickmn/go-cache"
 	"github.com/qingconglaixueit/wechatbot/config"
 	"time"
 )
 
 // UserServiceInterface 用户业务接口
 type UserServiceInterface interface {
-	GetUserSessionContext() string
-	SetUserSessionContext(question, reply string)
-	ClearUserSessionContext()
+	GetHistory() []Turn
+	AppendHistory(turns ...Turn)
+	TrimHistory(budget int)
+	ClearHistory()
+	UndoExchange() []Turn
+	LastExchange() []Turn
+	ReplaceExchange(exchange []Turn, turns ...Turn)
+	Shared() bool
+	AddUserImage(image []byte)
+	GetUserImages() [][]byte
+	TakeUserImages() [][]byte
+	SetExchangeImages(question Turn, images [][]byte)
+	ExchangeImages(question Turn) [][]byte
+	GetUserModel() string
+	SetUserModel(model string)
+	GetRole() string
+	SetRole(name string)
+	SystemPrompt() (name, prompt string)
+	Sessions() []SessionInfo
+	ActiveSession() string
+	NewSession(name string) error
+	SwitchSession(name string) error
+	RenameSession(name, newName string) error
+	DeleteSession(name string) error
+}
+
+// maxUserImages 会话中最多保留的图片数
+const maxUserImages = 3
+
+// exchangeImageStore 提问带的图片，重新回答时再带上，只放在内存中，不随会话历史写入bolt或redis
+var exchangeImageStore Store = NewMemoryStore()
+
+// exchangeImages 会话中最后一次带图片的提问的时间和图片
+type exchangeImages struct {
+	Question time.Time `json:"question"`
+	Images   [][]byte  `json:"images"`
 }
 
 var _ UserServiceInterface = (*UserService)(nil)
 
 // UserService 用戶业务
 type UserService struct {
-	// 缓存
-	cache *cache.Cache
+	// 会话存储
+	store Store
 	// 用户
 	user *openwechat.User
+	// 会话的所有者，默认为用户ID，群共享上下文时为群的key，创建的会话和当前会话都记在它下面
+	owner string
+	// 当前会话历史的key，默认会话为owner，创建的会话为owner#会话名
+	session string
+	// 是否为群共享上下文
+	shared bool
+	// 选择的角色的key，私聊按用户，群聊按群
+	roleKey string
+	// 所在的群，私聊时为空
+	group *openwechat.Group
+	// 图片的存储，私聊为会话存储，群里为内存
+	images Store
 }
 
 // NewUserService 创建新的业务层
-func NewUserService(cache *cache.Cache, user *openwechat.User) UserServiceInterface {
-	return &UserService{
-		cache: cache,
-		user:  user,
+func NewUserService(store Store, user *openwechat.User) UserServiceInterface {
+	s := &UserService{
+		store:   store,
+		user:    user,
+		owner:   user.ID(),
+		roleKey: userRoleKey(user.ID()),
+		images:  store,
 	}
+	s.session = s.sessionKey(s.ActiveSession())
+	return s
 }
 
-// ClearUserSessionContext 清空GTP上下文，接收文本中包含`我要问下一个问题`，并且Unicode 字符数量不超过20就清空
-func (s *UserService) ClearUserSessionContext() {
-	s.cache.Delete(s.user.ID())
+// NewGroupUserService 创建群成员的业务层，群开启了共享上下文时会话历史为全群共用，图片和模型仍按成员区分，
+// 群成员发的图片不一定是给机器人的，只放在内存中
+func NewGroupUserService(store Store, user *openwechat.User, group *openwechat.Group) UserServiceInterface {
+	s := &UserService{
+		store:   store,
+		user:    user,
+		owner:   user.ID(),
+		roleKey: groupRoleKey(group.ID()),
+		group:   group,
+		images:  groupImageStore,
+	}
+	if SharedContextEnabled(store, group) {
+		s.owner = sharedSessionKey(group)
+		s.shared = true
+	}
+	s.session = s.sessionKey(s.ActiveSession())
+	return s
+}
+
+// Shared 会话历史是否为群共享，共享时每条提问都要带上发言人
+func (s *UserService) Shared() bool {
+	return s.shared
 }
 
-// GetUserSessionContext 获取用户会话上下文文本
-func (s *UserService) GetUserSessionContext() string {
-	// 1.获取上次会话信息，如果没有直接返回空字符串
-	sessionContext, ok := s.cache.Get(s.user.ID())
+// ClearHistory 清空会话历史以及会话中的图片，接收文本中包含`我要问下一个问题`，并且Unicode 字符数量不超过20就清空
+func (s *UserService) ClearHistory() {
+	lock := sessionLock(s.session)
+	lock.Lock()
+	defer lock.Unlock()
+	s.delete(s.session)
+	deleteKey(s.images, s.imageKey())
+	deleteKey(exchangeImageStore, s.exchangeImageKey())
+}
+
+// GetHistory 获取会话历史，按时间顺序返回，超出模型上下文的部分在组装请求时按token裁剪
+func (s *UserService) GetHistory() []Turn {
+	var turns []Turn
+	s.get(s.session, &turns)
+	return turns
+}
+
+// AppendHistory 追加消息，每次追加重新计算过期时间。超过history_max_tokens时，开启了摘要的在后台把较早的对话
+// 压缩成摘要放在最前面，压缩完成前先完整保存，最多保留两倍上限；没开启摘要的只保留最近几轮
+func (s *UserService) AppendHistory(turns ...Turn) {
+	lock := sessionLock(s.session)
+	lock.Lock()
+	defer lock.Unlock()
+	s.appendHistory(s.GetHistory(), turns)
+}
+
+// appendHistory 在history后追加消息并保存，调用方持有会话的锁
+func (s *UserService) appendHistory(history, turns []Turn) {
+	history = append(history, turns...)
+	limit := historyLimit()
+	if countTurnsTokens(history) <= limit {
+		s.setHistory(history)
+		return
+	}
+	if !config.LoadConfig().SummarizeHistory {
+		s.setHistory(TrimTurns(history, limit))
+		return
+	}
+	s.setHistory(TrimTurns(history, limit*2))
+	s.summarize()
+}
+
+// TrimHistory 把会话历史裁剪到budget个token以内，从最新的消息往前保留
+func (s *UserService) TrimHistory(budget int) {
+	lock := sessionLock(s.session)
+	lock.Lock()
+	defer lock.Unlock()
+	turns := s.GetHistory()
+	if len(turns) == 0 {
+		return
+	}
+	s.setHistory(TrimTurns(turns, budget))
+}
+
+// UndoExchange 从会话历史中撤下最后一轮问答，返回撤下的提问和回复，没有可撤下的问答时返回空，
+// 之后的消息（如共享上下文的群里没@机器人的发言）保留
+func (s *UserService) UndoExchange() []Turn {
+	lock := sessionLock(s.session)
+	lock.Lock()
+	defer lock.Unlock()
+	turns := s.GetHistory()
+	question, answer, ok := lastExchange(turns)
+	if !ok {
+		return nil
+	}
+	undone := []Turn{turns[question], turns[answer]}
+	s.setHistory(append(turns[:question:question], turns[answer+1:]...))
+	return undone
+}
+
+// LastExchange 会话历史中最后一轮问答的提问和回复，没有问答时返回空
+func (s *UserService) LastExchange() []Turn {
+	turns := s.GetHistory()
+	question, answer, ok := lastExchange(turns)
 	if !ok {
-		return ""
+		return nil
 	}
+	return []Turn{turns[question], turns[answer]}
+}
+
+// ReplaceExchange 重新回答后用新的问答替换exchange这一轮，期间它已被撤销、替换或压缩时直接追加
+func (s *UserService) ReplaceExchange(exchange []Turn, turns ...Turn) {
+	lock := sessionLock(s.session)
+	lock.Lock()
+	defer lock.Unlock()
+	s.appendHistory(WithoutExchange(s.GetHistory(), exchange), turns)
+}
+
+// setHistory 保存会话历史，为空时删除
+func (s *UserService) setHistory(turns []Turn) {
+	if len(turns) == 0 {
+		s.delete(s.session)
+		return
+	}
+	s.set(s.session, turns, time.Second*config.LoadConfig().SessionTimeout)
+}
 
-	// 2.如果字符长度超过等于4000，强制清空会话（超过GPT会报错）。
-	contextText := sessionContext.(string)
-	if len(contextText) >= 4000 {
-		s.cache.Delete(s.user.ID())
+// AddUserImage 保存用户发来的图片，在配置的时间内提问都会带上，只保留最近几张
+func (s *UserService) AddUserImage(image []byte) {
+	lock := sessionLock(s.imageKey())
+	lock.Lock()
+	defer lock.Unlock()
+	images := append(s.GetUserImages(), image)
+	if len(images) > maxUserImages {
+		images = images[len(images)-maxUserImages:]
 	}
+	setJSON(s.images, s.imageKey(), images, time.Second*config.LoadConfig().VisionImageTimeout)
+}
+
+// GetUserImages 获取用户最近发来、还没过期的图片
+func (s *UserService) GetUserImages() [][]byte {
+	var images [][]byte
+	getJSON(s.images, s.imageKey(), &images)
+	return images
+}
+
+// TakeUserImages 取出用户最近发来的图片，取出后删除，只随这一次提问发送
+func (s *UserService) TakeUserImages() [][]byte {
+	lock := sessionLock(s.imageKey())
+	lock.Lock()
+	defer lock.Unlock()
+	images := s.GetUserImages()
+	if len(images) > 0 {
+		deleteKey(s.images, s.imageKey())
+	}
+	return images
+}
+
+// SetExchangeImages 记下提问带的图片，每个会话只保留最后一次带图片的提问的，随会话过期
+func (s *UserService) SetExchangeImages(question Turn, images [][]byte) {
+	if len(images) == 0 {
+		return
+	}
+	key := s.exchangeImageKey()
+	lock := sessionLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	setJSON(exchangeImageStore, key, exchangeImages{Question: question.Timestamp, Images: images}, time.Second*config.LoadConfig().SessionTimeout)
+}
+
+// ExchangeImages 重新回答时取回提问带的图片，之后又有带图片的提问或者已经过期时返回空
+func (s *UserService) ExchangeImages(question Turn) [][]byte {
+	var saved exchangeImages
+	if !getJSON(exchangeImageStore, s.exchangeImageKey(), &saved) || !saved.Question.Equal(question.Timestamp) {
+		return nil
+	}
+	return saved.Images
+}
+
+// GetUserModel 获取用户选择的模型，没有选择时返回空
+func (s *UserService) GetUserModel() string {
+	var model string
+	s.get(s.modelKey(), &model)
+	return model
+}
+
+// SetUserModel 设置用户之后提问使用的模型，不随会话过期和清空，model为空时恢复默认模型
+func (s *UserService) SetUserModel(model string) {
+	lock := sessionLock(s.modelKey())
+	lock.Lock()
+	defer lock.Unlock()
+	if model == "" {
+		s.delete(s.modelKey())
+		return
+	}
+	s.set(s.modelKey(), model, 0)
+}
+
+// GetRole 获取私聊用户或群选择的角色，没有选择时返回空
+func (s *UserService) GetRole() string {
+	var name string
+	s.get(s.roleKey, &name)
+	return name
+}
+
+// SetRole 设置私聊用户或群使用的角色，不随会话过期和清空，name为空时恢复全局默认角色
+func (s *UserService) SetRole(name string) {
+	if name == "" {
+		s.delete(s.roleKey)
+		return
+	}
+	s.set(s.roleKey, name, 0)
+}
+
+// SystemPrompt 当前使用的角色及其system prompt：先看私聊用户或群选择的角色，再看全局默认角色，
+// 角色已被删除时忽略，都没有时返回空
+func (s *UserService) SystemPrompt() (name, prompt string) {
+	roles := Roles(s.store)
+	for _, name := range []string{s.GetRole(), GlobalRole(s.store)} {
+		if prompt, ok := roles[name]; ok && name != "" {
+			return name, prompt
+		}
+	}
+	return "", ""
+}
+
+// imageKey 图片在缓存中的key，群里按群和成员区分
+func (s *UserService) imageKey() string {
+	if s.group != nil {
+		return "group:" + s.group.ID() + ":" + s.user.ID() + ":images"
+	}
+	return s.user.ID() + ":images"
+}
+
+// exchangeImageKey 提问带的图片在内存中的key，按会话区分
+func (s *UserService) exchangeImageKey() string {
+	return s.session + ":exchange-images"
+}
+
+// modelKey 选择的模型在缓存中的key
+func (s *UserService) modelKey() string {
+	return s.user.ID() + ":model"
+}
+
+// get 读取并反序列化存储中的数据，不存在或出错时返回false
+func (s *UserService) get(key string, value interface{}) bool {
+	return getJSON(s.store, key, value)
+}
 
-	// 3.返回上文
-	return contextText
+// set 序列化后写入存储，ttl不大于0时不过期
+func (s *UserService) set(key string, value interface{}, ttl time.Duration) {
+	setJSON(s.store, key, value, ttl)
 }
 
-// SetUserSessionContext 设置用户会话上下文文本，question用户提问内容，GTP回复内容
-func (s *UserService) SetUserSessionContext(question, reply string) {
-	value := question + "  " + reply
-	s.cache.Set(s.user.ID(), value, time.Second*config.LoadConfig().SessionTimeout)
+// delete 从存储中删除
+func (s *UserService) delete(key string) {
+	deleteKey(s.store, key)
 }
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eatmoreapple/openwechat"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+)
+
+// newTestUserService 创建使用内存存储的私聊业务层
+func newTestUserService(t *testing.T) UserServiceInterface {
+	store := NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	return NewUserService(store, &openwechat.User{Uin: 1})
+}
+
+func TestUndoExchange(t *testing.T) {
+	s := newTestUserService(t)
+	if undone := s.UndoExchange(); undone != nil {
+		t.Fatalf("UndoExchange on empty history = %v, want nil", undone)
+	}
+
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q1"), NewTurn(gpt.RoleAssistant, "a1"))
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q2"), NewTurn(gpt.RoleAssistant, "a2"))
+	s.AppendHistory(NewSpeakerTurn("路人", "闲聊"))
+
+	undone := s.UndoExchange()
+	if contents(undone) != "q2a2" {
+		t.Fatalf("UndoExchange = %s, want q2a2", contents(undone))
+	}
+	// 之后的发言保留
+	if got := contents(s.GetHistory()); got != "q1a1闲聊" {
+		t.Fatalf("history after undo = %s, want q1a1闲聊", got)
+	}
+	s.UndoExchange()
+	if got := s.GetHistory(); len(got) != 1 {
+		t.Fatalf("history after undoing everything = %s, want 闲聊", contents(got))
+	}
+	if undone = s.UndoExchange(); undone != nil {
+		t.Fatalf("UndoExchange without an exchange = %s, want nil", contents(undone))
+	}
+}
+
+func TestReplaceExchange(t *testing.T) {
+	s := newTestUserService(t)
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q1"), NewTurn(gpt.RoleAssistant, "a1"))
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q2"), NewTurn(gpt.RoleAssistant, "a2"))
+
+	retried := s.LastExchange()
+	if contents(retried) != "q2a2" {
+		t.Fatalf("LastExchange = %s, want q2a2", contents(retried))
+	}
+	s.ReplaceExchange(retried, NewTurn(gpt.RoleUser, "q2"), NewTurn(gpt.RoleAssistant, "b2"))
+	if got := contents(s.GetHistory()); got != "q1a1q2b2" {
+		t.Fatalf("history after retry = %s, want q1a1q2b2", got)
+	}
+
+	// 重新回答期间被撤销的，新的问答直接追加，不会删掉更早的一轮
+	s.UndoExchange()
+	s.ReplaceExchange(retried, NewTurn(gpt.RoleUser, "q2"), NewTurn(gpt.RoleAssistant, "c2"))
+	if got := contents(s.GetHistory()); got != "q1a1q2c2" {
+		t.Fatalf("history after retrying an undone exchange = %s, want q1a1q2c2", got)
+	}
+}
+
+func TestReplaceExchangeConcurrent(t *testing.T) {
+	s := newTestUserService(t)
+	s.AppendHistory(NewTurn(gpt.RoleUser, "q"), NewTurn(gpt.RoleAssistant, "a"))
+	retried := s.LastExchange()
+
+	// 同一轮同时重新回答多次，只有一次替换成功，其余追加，不会丢消息
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ReplaceExchange(retried, NewTurn(gpt.RoleUser, "q"), NewTurn(gpt.RoleAssistant, "b"))
+		}()
+	}
+	wg.Wait()
+	if got := len(s.GetHistory()); got != 2*n {
+		t.Fatalf("history has %d turns after %d concurrent retries, want %d", got, n, 2*n)
+	}
+}
+
+func TestExchangeImages(t *testing.T) {
+	store := NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	s := NewUserService(store, &openwechat.User{Uin: 2})
+
+	first := NewTurn(gpt.RoleUser, "q1").WithRequest("/gpt-4 q1", "gpt-4")
+	s.AppendHistory(first, NewTurn(gpt.RoleAssistant, "a1"))
+	s.SetExchangeImages(first, [][]byte{[]byte("image1")})
+
+	// 图片只放在内存中，不写入会话存储
+	data, _, _ := store.Get(s.(*UserService).session)
+	if strings.Contains(string(data), "images") {
+		t.Fatalf("session store holds the images: %s", data)
+	}
+	retried := s.LastExchange()
+	if images := s.ExchangeImages(retried[0]); len(images) != 1 || string(images[0]) != "image1" {
+		t.Fatalf("ExchangeImages = %q, want image1", images)
+	}
+	if retried[0].Request != "/gpt-4 q1" || retried[0].Model != "gpt-4" {
+		t.Fatalf("retried question = %+v, want its request and model kept", retried[0])
+	}
+
+	// 之后的提问没带图片时保留，撤销后重新回答仍能带上
+	second := NewTurn(gpt.RoleUser, "q2")
+	s.AppendHistory(second, NewTurn(gpt.RoleAssistant, "a2"))
+	s.SetExchangeImages(second, nil)
+	if images := s.ExchangeImages(second); images != nil {
+		t.Fatalf("ExchangeImages for a question without images = %q", images)
+	}
+	s.UndoExchange()
+	if images := s.ExchangeImages(s.LastExchange()[0]); len(images) != 1 {
+		t.Fatal("images lost after a question without images")
+	}
+
+	// 新的带图片的提问替换之前的
+	third := NewTurn(gpt.RoleUser, "q3")
+	s.AppendHistory(third, NewTurn(gpt.RoleAssistant, "a3"))
+	s.SetExchangeImages(third, [][]byte{[]byte("image3")})
+	if images := s.ExchangeImages(first); images != nil {
+		t.Fatalf("ExchangeImages for an older question = %q, want nil", images)
+	}
+
+	s.ClearHistory()
+	if images := s.ExchangeImages(third); images != nil {
+		t.Fatalf("ExchangeImages after ClearHistory = %q, want nil", images)
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	s := newTestUserService(t)
+	s.TrimHistory(100)
+	if history := s.GetHistory(); len(history) != 0 {
+		t.Fatalf("TrimHistory on empty history = %v", history)
+	}
+
+	for _, q := range []string{"q1", "q2", "q3"} {
+		s.AppendHistory(NewTurn(gpt.RoleUser, q), NewTurn(gpt.RoleAssistant, "a"+q[1:]))
+	}
+	history := s.GetHistory()
+	exchange := history[4].Tokens + history[5].Tokens
+
+	// 只够放下最后一轮
+	s.TrimHistory(exchange + history[3].Tokens - 1)
+	if got := contents(s.GetHistory()); got != "q3a3" {
+		t.Fatalf("history after TrimHistory = %s, want q3a3", got)
+	}
+	s.TrimHistory(exchange * 10)
+	if got := contents(s.GetHistory()); got != "q3a3" {
+		t.Fatalf("history after a larger budget = %s, want q3a3", got)
+	}
+	s.TrimHistory(0)
+	if history := s.GetHistory(); len(history) != 0 {
+		t.Fatalf("history after TrimHistory(0) = %s, want empty", contents(history))
+	}
+}
@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"12/4/3", 1},
+		{"7%3", 1},
+		{"2^3^2", 512},
+		{"-2^2", -4},
+		{"2^-1", 0.5},
+		{"--3", 3},
+		{"+4", 4},
+		{" 3 × 4 ", 12},
+		{"1.5e2", 150},
+		{"1e-3*1000", 1},
+		{".5+.5", 1},
+		{"sqrt(16)", 4},
+		{"ABS(-3)", 3},
+		{"round(2.5)+floor(1.9)+ceil(1.1)", 6},
+		{"log10(1000)", 3},
+		{"(1+2)*3^2/sqrt(16)", 6.75},
+		{"2*pi", 2 * math.Pi},
+		{"ln(e)", 1},
+	}
+	for _, tt := range tests {
+		got, err := Evaluate(tt.expression)
+		if err != nil {
+			t.Errorf("Evaluate(%q) error: %v", tt.expression, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Evaluate(%q) = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"1+",
+		"(1+2",
+		"1+2)",
+		"1/0",
+		"5%0",
+		"foo(1)",
+		"sqrt 4",
+		"2 3",
+		"1$2",
+		"sqrt(-1)",
+		"10^400",
+		"1..2",
+	} {
+		if got, err := Evaluate(expression); err == nil {
+			t.Errorf("Evaluate(%q) = %v, want error", expression, got)
+		}
+	}
+}
+
+func TestCalculatorCall(t *testing.T) {
+	calculator := Calculator()
+	got, err := calculator.Call(context.Background(), json.RawMessage(`{"expression":"0.1+0.2*10"}`))
+	if err != nil || got != "2.1" {
+		t.Fatalf("Call = %q, %v, want 2.1", got, err)
+	}
+	if _, err = calculator.Call(context.Background(), json.RawMessage(`{"expression":1}`)); err == nil {
+		t.Fatal("Call with invalid arguments returned no error")
+	}
+}
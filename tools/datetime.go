@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DateTime 当前日期时间工具，可以指定IANA时区
+func DateTime() *Tool {
+	return &Tool{
+		Name:        "current_datetime",
+		Description: "获取当前的日期、时间和星期，可以指定IANA时区，如Asia/Shanghai，默认服务器所在时区",
+		Parameters: json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"timezone": {"type": "string", "description": "IANA时区名称，如Asia/Shanghai、America/New_York"}
+	}
+}`),
+		Call: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var params struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(arguments, &params); err != nil {
+				return "", fmt.Errorf("invalid arguments: %v", err)
+			}
+			location := time.Local
+			if params.Timezone != "" {
+				loc, err := time.LoadLocation(params.Timezone)
+				if err != nil {
+					return "", fmt.Errorf("unknown timezone %s", params.Timezone)
+				}
+				location = loc
+			}
+			now := time.Now().In(location)
+			return fmt.Sprintf("%s %s (%s)", now.Format("2006-01-02 15:04:05"), now.Weekday(), location), nil
+		},
+	}
+}
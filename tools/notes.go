@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+)
+
+// maxNotesResult 一次最多返回的笔记数
+const maxNotesResult = 10
+
+// Note 机器人的笔记、提醒事项，存放在notes_file配置的JSON文件中
+type Note struct {
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags"`
+	// 提醒时间，可选
+	RemindAt string `json:"remind_at"`
+}
+
+// Notes 查询机器人笔记、提醒事项的工具，每次调用都重新读取文件，修改文件不用重启
+func Notes() *Tool {
+	return &Tool{
+		Name:        "search_notes",
+		Description: "按关键词查询机器人保存的笔记和提醒事项，关键词为空时返回最近的笔记",
+		Parameters: json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"keyword": {"type": "string", "description": "匹配标题、内容或标签的关键词"}
+	}
+}`),
+		Call: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var params struct {
+				Keyword string `json:"keyword"`
+			}
+			if err := json.Unmarshal(arguments, &params); err != nil {
+				return "", fmt.Errorf("invalid arguments: %v", err)
+			}
+			notes, err := loadNotes(config.LoadConfig().NotesFile)
+			if err != nil {
+				return "", err
+			}
+			matched := searchNotes(notes, params.Keyword)
+			if len(matched) == 0 {
+				return "没有找到相关的笔记", nil
+			}
+			result, err := json.Marshal(matched)
+			if err != nil {
+				return "", err
+			}
+			return string(result), nil
+		},
+	}
+}
+
+// loadNotes 读取笔记文件，文件不存在时没有笔记
+func loadNotes(filename string) ([]Note, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read notes error: %v", err)
+	}
+	var notes []Note
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, fmt.Errorf("decode notes error: %v", err)
+	}
+	return notes, nil
+}
+
+// searchNotes 按关键词匹配标题、内容、标签，忽略大小写，返回最近的几条
+func searchNotes(notes []Note, keyword string) []Note {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	var matched []Note
+	for i := len(notes) - 1; i >= 0 && len(matched) < maxNotesResult; i-- {
+		note := notes[i]
+		text := strings.ToLower(note.Title + "\n" + note.Content + "\n" + strings.Join(note.Tags, " "))
+		if keyword == "" || strings.Contains(text, keyword) {
+			matched = append(matched, note)
+		}
+	}
+	return matched
+}
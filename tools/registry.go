@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/qingconglaixueit/wechatbot/config"
+	"github.com/qingconglaixueit/wechatbot/gpt"
+	"github.com/qingconglaixueit/wechatbot/pkg/logger"
+)
+
+// Tool 提供给模型调用的Go函数，Parameters为参数的JSON schema
+type Tool struct {
+	// 工具名称，只能包含字母、数字、下划线
+	Name string
+	// 工具说明，模型根据说明决定何时调用
+	Description string
+	// 参数的JSON schema
+	Parameters json.RawMessage
+	// 执行函数，arguments为模型给出的JSON参数
+	Call func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// Registry 工具注册表
+type Registry struct {
+	lock  sync.RWMutex
+	tools map[string]*Tool
+	// 注册顺序，保证每次告知模型的工具顺序一致
+	names []string
+}
+
+// NewRegistry 创建空的工具注册表
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]*Tool{}}
+}
+
+// builtins 内置工具
+var builtins = map[string]func() *Tool{
+	"calculator": Calculator,
+	"datetime":   DateTime,
+	"notes":      Notes,
+}
+
+var (
+	defaultRegistry *Registry
+	defaultOnce     sync.Once
+)
+
+// Default 按配置启用内置工具的注册表
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = NewRegistry()
+		for _, name := range config.LoadConfig().Tools {
+			newTool, ok := builtins[name]
+			if !ok {
+				logger.Warning(fmt.Sprintf("unknown tool: %s", name))
+				continue
+			}
+			defaultRegistry.Register(newTool())
+		}
+	})
+	return defaultRegistry
+}
+
+// Register 注册工具，同名工具会被覆盖
+func (r *Registry) Register(tool *Tool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.tools[tool.Name]; !ok {
+		r.names = append(r.names, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+}
+
+// Len 已注册的工具数
+func (r *Registry) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.names)
+}
+
+// Definitions 通过chat的tools字段告知模型的工具定义
+func (r *Registry) Definitions() []gpt.Tool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	definitions := make([]gpt.Tool, 0, len(r.names))
+	for _, name := range r.names {
+		tool := r.tools[name]
+		definitions = append(definitions, gpt.Tool{
+			Type: "function",
+			Function: gpt.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return definitions
+}
+
+// Execute 执行模型要求的工具调用，出错时把错误信息作为结果交给模型处理
+func (r *Registry) Execute(ctx context.Context, call gpt.ToolCall) string {
+	r.lock.RLock()
+	tool, ok := r.tools[call.Function.Name]
+	r.lock.RUnlock()
+	if !ok {
+		return "error: unknown tool " + call.Function.Name
+	}
+	arguments := json.RawMessage(strings.TrimSpace(call.Function.Arguments))
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	result, err := tool.Call(ctx, arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}